@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a guarded operation may run: always while the
+// breaker is closed, and once per cooldown while it is open.
+func (cb *CircuitBreaker) allow(now time.Time) bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if !cb.open {
+		return true
+	}
+	if now.Sub(cb.probeAt) < cb.cooldown {
+		return false
+	}
+	cb.probeAt = now
+	return true
+}
+
+// record registers the outcome of a call and reports whether it opened or
+// closed the breaker, along with how long it had been open when it closed.
+func (cb *CircuitBreaker) record(success bool, now time.Time) (opened, closed bool, downtime time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if success {
+		cb.failures = 0
+		if cb.open {
+			cb.open = false
+			return false, true, now.Sub(cb.openedAt)
+		}
+		return false, false, 0
+	}
+
+	cb.failures++
+	if !cb.open && cb.failures >= cb.threshold {
+		cb.open = true
+		cb.openedAt = now
+		cb.probeAt = now
+		return true, false, 0
+	}
+	return false, false, 0
+}
+
+func (cb *CircuitBreaker) isOpen() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.open
+}
+
+// recordTwitchResult feeds the outcome of a Twitch API call to the breaker
+// and alerts the admin chat when it opens or closes. Only transient failures
+// count: a 4xx still proves Twitch is reachable.
+func (app *App) recordTwitchResult(err error) {
+	opened, closed, downtime := app.twitchBreaker.record(err == nil || !isTransientError(err), time.Now())
+
+	if opened {
+		log.Printf("Twitch API unreachable after %d consecutive failures, pausing polling: %v", app.config.BreakerThreshold, err)
+		go app.sendAdminAlert(fmt.Sprintf("🚨 <b>Twitch API unreachable</b>\n\nPolling is paused after %d consecutive failures and will be retried every %v.\n\n%s",
+			app.config.BreakerThreshold, app.config.BreakerCooldown, htmlCode(err.Error())))
+	}
+	if closed {
+		log.Printf("Twitch API reachable again after %v, resuming polling", downtime.Round(time.Second))
+		go app.sendAdminAlert(fmt.Sprintf("✅ <b>Twitch API reachable again</b>\n\nPolling resumed after %s of downtime.", formatDuration(downtime)))
+	}
+}
+
+func (app *App) sendAdminAlert(text string) {
+	if app.config.AdminChatID == 0 {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(app.config.AdminChatID, text)
+	if _, err := app.sendHTML(msg); err != nil {
+		log.Printf("Error sending admin alert: %v", err)
+	}
+}
+
+// retryDelay returns the jittered exponential backoff before retry number
+// attempt (starting at 1): a random delay between half and the full value of
+// base*2^(attempt-1), capped at max.
+func retryDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := base << (attempt - 1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleepContext waits for d and reports false if ctx was cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isTransientError reports whether a failed Twitch API call is worth
+// retrying: server errors, timeouts and dropped connections.
+func isTransientError(err error) bool {
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const (
+		allow = iota
+		success
+		failure
+	)
+
+	// Each step happens at a time relative to the start, with a threshold of
+	// 3 failures and a cooldown of a minute.
+	steps := []struct {
+		name string
+		at   time.Duration
+		op   int
+		// Result of allow, or whether record opened or closed the breaker
+		want     bool
+		wantOpen bool
+	}{
+		{"closed allows", 0, allow, true, false},
+		{"first failure", 0, failure, false, false},
+		{"success resets the count", 0, success, false, false},
+		{"failure 1", time.Second, failure, false, false},
+		{"failure 2", 2 * time.Second, failure, false, false},
+		{"failure 3 opens", 3 * time.Second, failure, true, true},
+		{"failure while open doesn't reopen", 4 * time.Second, failure, false, true},
+		{"open rejects", 30 * time.Second, allow, false, true},
+		{"half-open probe after the cooldown", 63 * time.Second, allow, true, true},
+		{"single probe per cooldown", 64 * time.Second, allow, false, true},
+		{"failed probe keeps it open", 64 * time.Second, failure, false, true},
+		{"rejects until the next cooldown", 2 * time.Minute, allow, false, true},
+		{"second probe", 124 * time.Second, allow, true, true},
+		{"successful probe closes", 125 * time.Second, success, true, false},
+		{"closed again allows", 125 * time.Second, allow, true, false},
+		{"failures below the threshold after recovery", 126 * time.Second, failure, false, false},
+		{"second outage", 127 * time.Second, failure, false, false},
+		{"second outage opens", 128 * time.Second, failure, true, true},
+	}
+
+	start := time.Now()
+	cb := newCircuitBreaker(3, time.Minute)
+	var opens, closes int
+	for _, step := range steps {
+		now := start.Add(step.at)
+
+		var got bool
+		switch step.op {
+		case allow:
+			got = cb.allow(now)
+		case success, failure:
+			opened, closed, downtime := cb.record(step.op == success, now)
+			if opened {
+				opens++
+			}
+			if closed {
+				closes++
+				if want := 122 * time.Second; downtime != want {
+					t.Errorf("%s: downtime = %v, want %v", step.name, downtime, want)
+				}
+			}
+			got = opened || closed
+		}
+
+		if got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+		if cb.isOpen() != step.wantOpen {
+			t.Errorf("%s: open = %v, want %v", step.name, cb.isOpen(), step.wantOpen)
+		}
+	}
+
+	// Admin alerts are sent when the breaker opens or closes: once each per
+	// outage, however many calls fail in between
+	if opens != 2 || closes != 1 {
+		t.Errorf("breaker opened %d and closed %d times, want 2 and 1", opens, closes)
+	}
+}
+
+func TestRecordTwitchResultIgnoresClientErrors(t *testing.T) {
+	app := &App{
+		config:        Config{BreakerThreshold: 1, BreakerCooldown: time.Minute},
+		twitchBreaker: newCircuitBreaker(1, time.Minute),
+	}
+
+	app.recordTwitchResult(&APIStatusError{StatusCode: 404})
+	if app.twitchBreaker.isOpen() {
+		t.Error("breaker opened on a 4xx")
+	}
+
+	app.recordTwitchResult(&APIStatusError{StatusCode: 503})
+	if !app.twitchBreaker.isOpen() {
+		t.Error("breaker still closed after a 5xx at threshold 1")
+	}
+}
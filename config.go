@@ -2,18 +2,87 @@ package main
 
 import (
 	"log"
+	"net/mail"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 const (
-	DefaultPollingInterval = 90 * time.Second
-	MinPollingInterval     = 30 * time.Second
-	DefaultHTTPTimeout     = 10 * time.Second
-	StreamersFilePath      = "/data/streamers.json"
+	DefaultPollingInterval      = 90 * time.Second
+	MinPollingInterval          = 30 * time.Second
+	DefaultHTTPTimeout          = 10 * time.Second
+	StreamersFilePath           = "/data/streamers.json"
+	DefaultSQLitePath           = "/data/streamers.db"
+	StreamersFileVersion        = 2
+	EventSubWebSocketURL        = "wss://eventsub.wss.twitch.tv/ws"
+	EventSubMaxBackoff          = 2 * time.Minute
+	WebhookMaxMessageAge        = 10 * time.Minute
+	WebhookMaxClockSkew         = time.Minute
+	DefaultHTTPListenAddr       = ":8080"
+	ThumbnailWidth              = 1280
+	ThumbnailHeight             = 720
+	DefaultUpdateCooldown       = 5 * time.Minute
+	LiveMessageEditInterval     = 10 * time.Minute
+	DefaultOfflineMissThreshold = 2
+	DefaultHistoryMaxSessions   = 100
+	DefaultHistoryMaxAgeDays    = 365
+	DefaultHistoryLength        = 5
+	MaxHistoryLength            = 20
+	DefaultReadyPollFactor      = 3
+	TwitchRateLimitDefault      = 800
+	TwitchRateLimitWindow       = time.Minute
+	MaxRateLimitRetries         = 3
+	TokenValidationInterval     = time.Hour
+	TwitchMaxRetries            = 3
+	TwitchRetryBaseDelay        = time.Second
+	TwitchRetryMaxDelay         = 10 * time.Second
+	DefaultBreakerThreshold     = 3
+	DefaultBreakerCooldown      = 5 * time.Minute
+	DeadLetterFilePath          = "/data/dead_letters.jsonl"
+	TelegramGlobalRate          = 30
+	TelegramPrivateInterval     = time.Second
+	TelegramGroupInterval       = 3 * time.Second
+	TelegramMaxAttempts         = 5
+	TelegramRetryBaseDelay      = time.Second
+	TelegramRetryMaxDelay       = 30 * time.Second
+	OutboxMaxAttempts           = 10
+	OutboxRetryBaseDelay        = 30 * time.Second
+	OutboxRetryMaxDelay         = 30 * time.Minute
+	TelegramQueueSize           = 100
+	TelegramUpdatesTimeout      = 60 * time.Second
+	TelegramUpdatesMargin       = 30 * time.Second
+	TelegramUpdatesRetryDelay   = 3 * time.Second
+	WebhookPayloadVersion       = 1
+	DefaultNotifyWebhookTimeout = 10 * time.Second
+	WebhookDeliveryLogPath      = "/data/webhook_deliveries.jsonl"
+	WebhookDeliveryLogMaxSize   = 5 << 20
+	DefaultSlackWebhookHost     = "hooks.slack.com"
+	DefaultNtfyServerURL        = "https://ntfy.sh"
+	DefaultNtfyPriority         = 4
+	DefaultGotifyPriority       = 8
+	DefaultSMTPPort             = 587
+	DefaultEmailDigestTime      = 8 * time.Hour
+	SMTPTimeout                 = 30 * time.Second
+	EmailDigestMaxAttempts      = 5
+	EmailDigestRetryBaseDelay   = time.Minute
+	EmailDigestRetryMaxDelay    = 30 * time.Minute
+)
+
+// Email modes
+const (
+	EmailModeImmediate = "immediate"
+	EmailModeDigest    = "digest"
+)
+
+// SMTP connection security
+const (
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityNone     = "none"
 )
 
 func loadConfig() Config {
@@ -22,9 +91,35 @@ func loadConfig() Config {
 		log.Println("Warning: .env file not found, using environment variables")
 	}
 
-	chatID, err := strconv.ParseInt(os.Getenv("TELEGRAM_CHAT_ID"), 10, 64)
-	if err != nil {
-		log.Fatal("Invalid TELEGRAM_CHAT_ID:", err)
+	var chatID int64
+	if env := os.Getenv("TELEGRAM_CHAT_ID"); env != "" {
+		chatID, err = strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			log.Fatal("Invalid TELEGRAM_CHAT_ID:", err)
+		}
+	}
+
+	allowedChatIDs := make(map[int64]bool)
+	allowAllChats := false
+	for _, entry := range strings.Split(os.Getenv("TELEGRAM_ALLOWED_CHAT_IDS"), ",") {
+		entry = strings.TrimSpace(entry)
+		switch entry {
+		case "":
+		case "*":
+			allowAllChats = true
+		default:
+			id, err := strconv.ParseInt(entry, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid chat ID %q in TELEGRAM_ALLOWED_CHAT_IDS: %v", entry, err)
+			}
+			allowedChatIDs[id] = true
+		}
+	}
+	if chatID != 0 {
+		allowedChatIDs[chatID] = true
+	}
+	if len(allowedChatIDs) == 0 && !allowAllChats {
+		log.Fatal("Set TELEGRAM_CHAT_ID or TELEGRAM_ALLOWED_CHAT_IDS to choose which chats may use the bot")
 	}
 
 	pollingInterval := DefaultPollingInterval
@@ -34,11 +129,329 @@ func loadConfig() Config {
 		}
 	}
 
+	httpListenAddr := DefaultHTTPListenAddr
+	if env := os.Getenv("HTTP_LISTEN_ADDR"); env != "" {
+		httpListenAddr = env
+	}
+
+	webhookSecret := os.Getenv("EVENTSUB_WEBHOOK_SECRET")
+	if webhookSecret != "" && (len(webhookSecret) < 10 || len(webhookSecret) > 100) {
+		log.Fatal("EVENTSUB_WEBHOOK_SECRET must be between 10 and 100 characters")
+	}
+
+	notificationTemplate := os.Getenv("NOTIFICATION_TEMPLATE")
+	if notificationTemplate != "" {
+		if _, err := validateNotificationTemplate(notificationTemplate); err != nil {
+			log.Fatal("Invalid NOTIFICATION_TEMPLATE:", err)
+		}
+	}
+
+	offlineNotifications := false
+	if env := os.Getenv("OFFLINE_NOTIFICATIONS"); env != "" {
+		offlineNotifications, err = strconv.ParseBool(env)
+		if err != nil {
+			log.Fatal("Invalid OFFLINE_NOTIFICATIONS:", err)
+		}
+	}
+
+	updateNotifications := false
+	if env := os.Getenv("UPDATE_NOTIFICATIONS"); env != "" {
+		updateNotifications, err = strconv.ParseBool(env)
+		if err != nil {
+			log.Fatal("Invalid UPDATE_NOTIFICATIONS:", err)
+		}
+	}
+
+	updateCooldown := DefaultUpdateCooldown
+	if env := os.Getenv("UPDATE_NOTIFICATION_COOLDOWN_SECONDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 0 {
+			updateCooldown = time.Duration(val) * time.Second
+		}
+	}
+
+	offlineMissThreshold := DefaultOfflineMissThreshold
+	if env := os.Getenv("OFFLINE_MISS_THRESHOLD"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			offlineMissThreshold = val
+		}
+	}
+
+	var offlineGracePeriod time.Duration
+	if env := os.Getenv("OFFLINE_GRACE_PERIOD_SECONDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 0 {
+			offlineGracePeriod = time.Duration(val) * time.Second
+		}
+	}
+
+	storageBackend := "json"
+	if env := os.Getenv("STORAGE_BACKEND"); env != "" {
+		storageBackend = strings.ToLower(env)
+		if storageBackend != "json" && storageBackend != "sqlite" {
+			log.Fatalf("Invalid STORAGE_BACKEND %q (must be json or sqlite)", env)
+		}
+	}
+
+	sqlitePath := DefaultSQLitePath
+	if env := os.Getenv("SQLITE_PATH"); env != "" {
+		sqlitePath = env
+	}
+
+	historyMaxSessions := DefaultHistoryMaxSessions
+	if env := os.Getenv("HISTORY_MAX_SESSIONS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			historyMaxSessions = val
+		}
+	}
+
+	historyMaxAge := DefaultHistoryMaxAgeDays * 24 * time.Hour
+	if env := os.Getenv("HISTORY_MAX_AGE_DAYS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 0 {
+			historyMaxAge = time.Duration(val) * 24 * time.Hour
+		}
+	}
+
+	readyPollFactor := DefaultReadyPollFactor
+	if env := os.Getenv("READY_POLL_FACTOR"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			readyPollFactor = val
+		}
+	}
+
+	adminChatID := chatID
+	if env := os.Getenv("ADMIN_CHAT_ID"); env != "" {
+		adminChatID, err = strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			log.Fatal("Invalid ADMIN_CHAT_ID:", err)
+		}
+	}
+
+	webhookCallbackURL := os.Getenv("EVENTSUB_WEBHOOK_CALLBACK_URL")
+	if webhookCallbackURL != "" {
+		if err := validateWebhookCallbackURL(webhookCallbackURL); err != nil {
+			log.Fatalf("Invalid EVENTSUB_WEBHOOK_CALLBACK_URL: %v", err)
+		}
+	}
+
+	breakerThreshold := DefaultBreakerThreshold
+	if env := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			breakerThreshold = val
+		}
+	}
+
+	breakerCooldown := DefaultBreakerCooldown
+	if env := os.Getenv("CIRCUIT_BREAKER_COOLDOWN_SECONDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			breakerCooldown = time.Duration(val) * time.Second
+		}
+	}
+
+	var notifyWebhookURLs []string
+	for _, entry := range strings.Split(os.Getenv("NOTIFY_WEBHOOK_URLS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := validateWebhookURL(entry); err != nil {
+			log.Fatalf("Invalid URL %q in NOTIFY_WEBHOOK_URLS: %v", entry, err)
+		}
+		notifyWebhookURLs = append(notifyWebhookURLs, entry)
+	}
+
+	notifyWebhookSecret := os.Getenv("NOTIFY_WEBHOOK_SECRET")
+	if len(notifyWebhookURLs) > 0 && notifyWebhookSecret == "" {
+		log.Fatal("NOTIFY_WEBHOOK_SECRET is required to sign events sent to NOTIFY_WEBHOOK_URLS")
+	}
+
+	notifyWebhookTimeout := DefaultNotifyWebhookTimeout
+	if env := os.Getenv("NOTIFY_WEBHOOK_TIMEOUT_SECONDS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 {
+			notifyWebhookTimeout = time.Duration(val) * time.Second
+		}
+	}
+
+	slackWebhookHosts := []string{DefaultSlackWebhookHost}
+	if env := os.Getenv("SLACK_WEBHOOK_HOSTS"); env != "" {
+		slackWebhookHosts = nil
+		for _, host := range strings.Split(env, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				slackWebhookHosts = append(slackWebhookHosts, host)
+			}
+		}
+	}
+
+	matrixHomeserverURL := strings.TrimSuffix(strings.TrimSpace(os.Getenv("MATRIX_HOMESERVER_URL")), "/")
+	matrixAccessToken := os.Getenv("MATRIX_ACCESS_TOKEN")
+	if matrixHomeserverURL != "" {
+		if err := validateWebhookURL(matrixHomeserverURL); err != nil {
+			log.Fatalf("Invalid MATRIX_HOMESERVER_URL: %v", err)
+		}
+		if matrixAccessToken == "" {
+			log.Fatal("MATRIX_ACCESS_TOKEN is required when MATRIX_HOMESERVER_URL is set")
+		}
+	}
+
+	ntfyServerURL := DefaultNtfyServerURL
+	if env := strings.TrimSuffix(strings.TrimSpace(os.Getenv("NTFY_SERVER_URL")), "/"); env != "" {
+		ntfyServerURL = env
+	}
+	ntfyTopic := strings.TrimSpace(os.Getenv("NTFY_TOPIC"))
+	if ntfyTopic != "" {
+		if err := validateWebhookURL(ntfyServerURL); err != nil {
+			log.Fatalf("Invalid NTFY_SERVER_URL: %v", err)
+		}
+		if !ntfyTopicPattern.MatchString(ntfyTopic) {
+			log.Fatal("Invalid NTFY_TOPIC: use 1-64 letters, digits, - and _")
+		}
+	}
+
+	ntfyPriority := DefaultNtfyPriority
+	if env := os.Getenv("NTFY_PRIORITY"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 && val <= 5 {
+			ntfyPriority = val
+		}
+	}
+
+	var ntfyTags []string
+	for _, tag := range strings.Split(os.Getenv("NTFY_TAGS"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			ntfyTags = append(ntfyTags, tag)
+		}
+	}
+
+	gotifyURL := strings.TrimSuffix(strings.TrimSpace(os.Getenv("GOTIFY_URL")), "/")
+	gotifyToken := os.Getenv("GOTIFY_TOKEN")
+	if gotifyURL != "" {
+		if err := validateWebhookURL(gotifyURL); err != nil {
+			log.Fatalf("Invalid GOTIFY_URL: %v", err)
+		}
+		if gotifyToken == "" {
+			log.Fatal("GOTIFY_TOKEN is required when GOTIFY_URL is set")
+		}
+	}
+
+	gotifyPriority := DefaultGotifyPriority
+	if env := os.Getenv("GOTIFY_PRIORITY"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 0 && val <= 10 {
+			gotifyPriority = val
+		}
+	}
+
+	var smtpTo []string
+	for _, address := range strings.Split(os.Getenv("SMTP_TO"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			if _, err := mail.ParseAddress(address); err != nil {
+				log.Fatalf("Invalid address %q in SMTP_TO: %v", address, err)
+			}
+			smtpTo = append(smtpTo, address)
+		}
+	}
+
+	smtpHost := strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpFrom := os.Getenv("SMTP_FROM")
+	if smtpFrom == "" {
+		smtpFrom = smtpUsername
+	}
+	if smtpHost != "" {
+		if len(smtpTo) == 0 {
+			log.Fatal("SMTP_TO is required when SMTP_HOST is set")
+		}
+		if _, err := mail.ParseAddress(smtpFrom); err != nil {
+			log.Fatalf("Invalid SMTP_FROM %q (defaults to SMTP_USERNAME): %v", smtpFrom, err)
+		}
+	}
+
+	smtpPort := DefaultSMTPPort
+	if env := os.Getenv("SMTP_PORT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil && val >= 1 && val <= 65535 {
+			smtpPort = val
+		}
+	}
+
+	smtpSecurity := SMTPSecurityStartTLS
+	if env := strings.ToLower(os.Getenv("SMTP_SECURITY")); env != "" {
+		switch env {
+		case SMTPSecurityStartTLS, SMTPSecurityTLS, SMTPSecurityNone:
+			smtpSecurity = env
+		default:
+			log.Fatalf("Invalid SMTP_SECURITY %q: use starttls, tls or none", env)
+		}
+	}
+	// net/smtp only sends the password in clear text to the local host, so
+	// fail now rather than on every mail
+	if smtpHost != "" && smtpSecurity == SMTPSecurityNone && smtpUsername != "" &&
+		smtpHost != "localhost" && smtpHost != "127.0.0.1" && smtpHost != "::1" {
+		log.Fatal("SMTP_USERNAME requires SMTP_SECURITY=starttls or tls unless SMTP_HOST is localhost")
+	}
+
+	emailMode := EmailModeImmediate
+	if env := strings.ToLower(os.Getenv("EMAIL_MODE")); env != "" {
+		switch env {
+		case EmailModeImmediate, EmailModeDigest:
+			emailMode = env
+		default:
+			log.Fatalf("Invalid EMAIL_MODE %q: use immediate or digest", env)
+		}
+	}
+
+	emailDigestTime := DefaultEmailDigestTime
+	if env := os.Getenv("EMAIL_DIGEST_TIME"); env != "" {
+		parsed, err := time.Parse("15:04", env)
+		if err != nil {
+			log.Fatalf("Invalid EMAIL_DIGEST_TIME %q: use HH:MM", env)
+		}
+		emailDigestTime = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+
 	return Config{
-		TwitchClientID:     os.Getenv("TWITCH_CLIENT_ID"),
-		TwitchClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
-		TelegramBotToken:   os.Getenv("TELEGRAM_BOT_TOKEN"),
-		TelegramChatID:     chatID,
-		PollingInterval:    pollingInterval,
+		TwitchClientID:       os.Getenv("TWITCH_CLIENT_ID"),
+		TwitchClientSecret:   os.Getenv("TWITCH_CLIENT_SECRET"),
+		TwitchUserToken:      os.Getenv("TWITCH_USER_TOKEN"),
+		WebhookCallbackURL:   webhookCallbackURL,
+		WebhookSecret:        webhookSecret,
+		HTTPListenAddr:       httpListenAddr,
+		TelegramBotToken:     os.Getenv("TELEGRAM_BOT_TOKEN"),
+		TelegramChatID:       chatID,
+		AllowedChatIDs:       allowedChatIDs,
+		AllowAllChats:        allowAllChats,
+		PollingInterval:      pollingInterval,
+		NotificationTemplate: notificationTemplate,
+		OfflineNotifications: offlineNotifications,
+		UpdateNotifications:  updateNotifications,
+		UpdateCooldown:       updateCooldown,
+		OfflineMissThreshold: offlineMissThreshold,
+		OfflineGracePeriod:   offlineGracePeriod,
+		StorageBackend:       storageBackend,
+		SQLitePath:           sqlitePath,
+		HistoryMaxSessions:   historyMaxSessions,
+		HistoryMaxAge:        historyMaxAge,
+		ReadyPollFactor:      readyPollFactor,
+		AdminChatID:          adminChatID,
+		BreakerThreshold:     breakerThreshold,
+		BreakerCooldown:      breakerCooldown,
+		NotifyWebhookURLs:    notifyWebhookURLs,
+		NotifyWebhookSecret:  notifyWebhookSecret,
+		NotifyWebhookTimeout: notifyWebhookTimeout,
+		SlackWebhookHosts:    slackWebhookHosts,
+		MatrixHomeserverURL:  matrixHomeserverURL,
+		MatrixAccessToken:    matrixAccessToken,
+		NtfyServerURL:        ntfyServerURL,
+		NtfyTopic:            ntfyTopic,
+		NtfyToken:            os.Getenv("NTFY_TOKEN"),
+		NtfyPriority:         ntfyPriority,
+		NtfyTags:             ntfyTags,
+		GotifyURL:            gotifyURL,
+		GotifyToken:          gotifyToken,
+		GotifyPriority:       gotifyPriority,
+		SMTPHost:             smtpHost,
+		SMTPPort:             smtpPort,
+		SMTPUsername:         smtpUsername,
+		SMTPPassword:         os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:             smtpFrom,
+		SMTPTo:               smtpTo,
+		SMTPSecurity:         smtpSecurity,
+		EmailMode:            emailMode,
+		EmailDigestTime:      emailDigestTime,
 	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Embed colours
+const (
+	discordColorLive    = 0x9146FF
+	discordColorOffline = 0x747F8D
+	discordColorUpdate  = 0x3498DB
+)
+
+// Discord embed limits
+const (
+	discordMaxTitleLength = 256
+	discordMaxFieldLength = 1024
+)
+
+func (notifier *DiscordNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	embed := DiscordEmbed{
+		Title:       truncateText(event.DisplayName+" is now live!", discordMaxTitleLength),
+		URL:         event.channelURL(),
+		Description: truncateText(event.Title, discordMaxFieldLength),
+		Color:       discordColorLive,
+		Author:      &DiscordEmbedAuthor{Name: event.DisplayName, URL: event.channelURL()},
+	}
+	if event.GameName != "" {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Game", Value: truncateText(event.GameName, discordMaxFieldLength), Inline: true})
+	}
+	embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Viewers", Value: fmt.Sprintf("%d", event.ViewerCount), Inline: true})
+	if event.ThumbnailURL != "" {
+		embed.Image = &DiscordEmbedImage{URL: event.ThumbnailURL}
+	}
+	if !event.StartedAt.IsZero() {
+		embed.Timestamp = event.StartedAt.UTC().Format(time.RFC3339)
+	}
+
+	// Webhook messages aren't edited as the stream goes on
+	return nil, notifier.post(target.URL, embed)
+}
+
+func (notifier *DiscordNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	embed := DiscordEmbed{
+		Title:       truncateText(event.DisplayName+" went offline", discordMaxTitleLength),
+		URL:         event.channelURL(),
+		Description: truncateText(event.Title, discordMaxFieldLength),
+		Color:       discordColorOffline,
+		Author:      &DiscordEmbedAuthor{Name: event.DisplayName, URL: event.channelURL()},
+		Timestamp:   event.EndedAt.UTC().Format(time.RFC3339),
+	}
+	if event.GameName != "" {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Game", Value: truncateText(event.GameName, discordMaxFieldLength), Inline: true})
+	}
+	if !event.StartedAt.IsZero() {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Duration", Value: formatDuration(event.EndedAt.Sub(event.StartedAt)), Inline: true})
+	}
+	return notifier.post(target.URL, embed)
+}
+
+func (notifier *DiscordNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	embed := DiscordEmbed{
+		Title:  truncateText(event.DisplayName+" updated the stream", discordMaxTitleLength),
+		URL:    event.channelURL(),
+		Color:  discordColorUpdate,
+		Author: &DiscordEmbedAuthor{Name: event.DisplayName, URL: event.channelURL()},
+	}
+	if event.GameChanged {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Category", Value: truncateText(event.GameName, discordMaxFieldLength)})
+	}
+	if event.TitleChanged {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Title", Value: truncateText(event.Title, discordMaxFieldLength)})
+	}
+	return notifier.post(target.URL, embed)
+}
+
+// post executes the webhook, see requestJSON.
+func (notifier *DiscordNotifier) post(webhookURL string, embed DiscordEmbed) error {
+	return postJSON(notifier.ctx, notifier.client, webhookURL, nil, DiscordWebhookPayload{Embeds: []DiscordEmbed{embed}})
+}
+
+// checkDiscordWebhook fetches the webhook to make sure its URL is valid
+// before it is saved as a target.
+func (app *App) checkDiscordWebhook(webhookURL string) error {
+	req, err := http.NewRequestWithContext(app.ctx, http.MethodGet, webhookURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := app.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Discord answered HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// truncateText shortens text to at most limit characters, marking the cut
+// with an ellipsis.
+func truncateText(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit-1]) + "…"
+}
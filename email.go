@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (notifier *EmailNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	return nil, notifier.send(newEventEmail(outboxLive, event))
+}
+
+func (notifier *EmailNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	return notifier.send(newEventEmail(outboxOffline, event))
+}
+
+func (notifier *EmailNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	return notifier.send(newEventEmail(outboxUpdate, event))
+}
+
+// newEventEmail turns an event into a mail with the same content as push
+// notifications. The message ID is derived from the event, so a mail resent
+// after a failed QUIT can be recognized as a duplicate.
+func newEventEmail(kind string, event *NotificationEvent) EmailMessage {
+	title, body := pushText(kind, event)
+
+	var html strings.Builder
+	fmt.Fprintf(&html, "<h2>%s</h2>\n", htmlLink(event.channelURL(), title))
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			fmt.Fprintf(&html, "<p>%s</p>\n", escapeHTML(line))
+		}
+	}
+	if kind == outboxLive && event.ThumbnailURL != "" {
+		fmt.Fprintf(&html, "<p><img src=\"%s\" alt=\"Stream preview\" width=\"640\"></p>\n", escapeHTML(event.ThumbnailURL))
+	}
+	fmt.Fprintf(&html, "<p>%s</p>\n", htmlLink(event.channelURL(), "Watch on Twitch"))
+
+	return EmailMessage{
+		ID:      event.ID + "-" + kind,
+		Subject: title,
+		Text:    body + "\n\n" + event.channelURL() + "\n",
+		HTML:    html.String(),
+	}
+}
+
+// send delivers a mail to every recipient, upgrading the connection with
+// STARTTLS (or using implicit TLS) as configured.
+func (notifier *EmailNotifier) send(message EmailMessage) error {
+	if notifier.host == "" {
+		return fmt.Errorf("%w: SMTP_HOST is not set", errOutboxStale)
+	}
+
+	from, err := mail.ParseAddress(notifier.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	data, err := notifier.buildMessage(message)
+	if err != nil {
+		return err
+	}
+
+	client, err := notifier.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if notifier.security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS", notifier.host)
+		}
+		if err := client.StartTLS(notifier.clientTLSConfig()); err != nil {
+			return err
+		}
+	}
+	if notifier.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", notifier.username, notifier.password, notifier.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range notifier.to {
+		to, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient: %w", err)
+		}
+		if err := client.Rcpt(to.Address); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (notifier *EmailNotifier) dial() (*smtp.Client, error) {
+	ctx, cancel := context.WithTimeout(notifier.ctx, SMTPTimeout)
+	defer cancel()
+
+	address := net.JoinHostPort(notifier.host, strconv.Itoa(notifier.port))
+	var conn net.Conn
+	var err error
+	if notifier.security == SMTPSecurityTLS {
+		dialer := &tls.Dialer{Config: notifier.clientTLSConfig()}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Bound the whole conversation, not just the connection
+	conn.SetDeadline(time.Now().Add(SMTPTimeout))
+
+	client, err := smtp.NewClient(conn, notifier.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (notifier *EmailNotifier) clientTLSConfig() *tls.Config {
+	if notifier.tlsConfig != nil {
+		return notifier.tlsConfig
+	}
+	return &tls.Config{ServerName: notifier.host}
+}
+
+// buildMessage renders a multipart/alternative mail with quoted-printable
+// plain-text and HTML parts.
+func (notifier *EmailNotifier) buildMessage(message EmailMessage) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	headers := [][2]string{
+		{"From", notifier.from},
+		{"To", strings.Join(notifier.to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@tgtping>", message.ID)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary())},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&data, "%s: %s\r\n", header[0], header[1])
+	}
+	data.WriteString("\r\n")
+	data.Write(body.Bytes())
+	return data.Bytes(), nil
+}
+
+// startEmailDigest starts the daily digest when EMAIL_MODE=digest.
+func (app *App) startEmailDigest() {
+	if app.config.SMTPHost == "" || app.config.EmailMode != EmailModeDigest {
+		return
+	}
+	log.Printf("Email digest enabled, next one at %s", nextDigestTime(time.Now(), app.config.EmailDigestTime).Format(time.RFC3339))
+	go app.runEmailDigest()
+}
+
+func (app *App) runEmailDigest() {
+	for {
+		next := nextDigestTime(time.Now(), app.config.EmailDigestTime)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-app.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		app.sendEmailDigest(next.AddDate(0, 0, -1), next)
+	}
+}
+
+// nextDigestTime returns the first time after now at the given time of day,
+// as an offset from midnight. The hour and minute are set on the calendar
+// rather than added to midnight, so the digest keeps its local time across
+// DST changes.
+func nextDigestTime(now time.Time, offset time.Duration) time.Time {
+	year, month, day := now.Date()
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	next := time.Date(year, month, day, hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(year, month, day+1, hour, minute, 0, 0, now.Location())
+	}
+	return next
+}
+
+// sendEmailDigest mails the sessions that ended between since and until,
+// and the streams still live, retrying failures with backoff. Nothing is sent
+// for a day without streams.
+func (app *App) sendEmailDigest(since, until time.Time) {
+	records := app.digestSessions(since, until)
+	var live []*Streamer
+	for _, streamer := range app.streamerManager.getStreamers() {
+		if streamer.Session != nil {
+			live = append(live, streamer)
+		}
+	}
+	if len(records) == 0 && len(live) == 0 {
+		log.Printf("No streams since %s, skipping email digest", since.Format(time.RFC3339))
+		return
+	}
+
+	notifier := app.notifiers[notifierEmail].(*EmailNotifier)
+	message := newDigestEmail(since, records, live)
+	for attempt := 1; ; attempt++ {
+		err := notifier.send(message)
+		if err == nil {
+			log.Printf("Sent email digest with %d sessions to %s", len(records), strings.Join(app.config.SMTPTo, ", "))
+			app.metrics.notifications.inc("digest", "sent")
+			return
+		}
+		app.metrics.notifications.inc("digest", "failed")
+		if !isRetryableNotificationError(err) || attempt >= EmailDigestMaxAttempts {
+			log.Printf("Giving up on email digest after %d attempts: %v", attempt, err)
+			app.metrics.notifications.inc("digest", "dropped")
+			return
+		}
+
+		delay := retryDelay(attempt, EmailDigestRetryBaseDelay, EmailDigestRetryMaxDelay)
+		log.Printf("Error sending email digest (attempt %d/%d), retrying in %v: %v", attempt, EmailDigestMaxAttempts, delay.Round(time.Second), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-app.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// digestSessions returns the recorded sessions that ended between since and
+// until, oldest first.
+func (app *App) digestSessions(since, until time.Time) []SessionRecord {
+	var records []SessionRecord
+	for _, streamer := range app.streamerManager.getStreamers() {
+		history, err := app.streamerManager.getSessionHistory(streamer.Username, app.config.HistoryMaxSessions)
+		if err != nil {
+			log.Printf("Error loading history of %s for the email digest: %v", streamer.Username, err)
+			continue
+		}
+		// History is newest first
+		for _, record := range history {
+			if record.EndedAt.Before(since) {
+				break
+			}
+			if record.EndedAt.Before(until) {
+				records = append(records, record)
+			}
+		}
+	}
+	slices.SortFunc(records, func(a, b SessionRecord) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return records
+}
+
+// newDigestEmail summarizes the day starting at day: the streams that ended
+// since, as a table in HTML, and the ones still live.
+func newDigestEmail(day time.Time, records []SessionRecord, live []*Streamer) EmailMessage {
+	var text, html strings.Builder
+	subject := fmt.Sprintf("Twitch digest for %s: %d streams", day.Format("Mon Jan 2"), len(records))
+	if len(records) == 1 {
+		subject = fmt.Sprintf("Twitch digest for %s: 1 stream", day.Format("Mon Jan 2"))
+	}
+
+	if len(records) > 0 {
+		text.WriteString("Streams:\n")
+		html.WriteString("<h2>Streams</h2>\n<table border=\"1\" cellpadding=\"6\" cellspacing=\"0\">\n")
+		html.WriteString("<tr><th>Streamer</th><th>Started</th><th>Duration</th><th>Games</th><th>Peak viewers</th></tr>\n")
+		for _, record := range records {
+			started := record.StartedAt.Local().Format("Jan 2 15:04")
+			duration := formatDuration(record.EndedAt.Sub(record.StartedAt))
+			games := strings.Join(record.Games, ", ")
+			fmt.Fprintf(&text, "- %s: %s for %s", record.DisplayName, started, duration)
+			if games != "" {
+				fmt.Fprintf(&text, ", %s", games)
+			}
+			fmt.Fprintf(&text, ", peak %d viewers\n", record.PeakViewers)
+			fmt.Fprintf(&html, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%d</td></tr>\n",
+				htmlLink("https://twitch.tv/"+record.Username, record.DisplayName), started, duration, escapeHTML(games), record.PeakViewers)
+		}
+		html.WriteString("</table>\n")
+	}
+
+	if len(live) > 0 {
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		text.WriteString("Live now:\n")
+		html.WriteString("<h2>Live now</h2>\n<ul>\n")
+		for _, streamer := range live {
+			session := streamer.Session
+			started := session.StartedAt.Local().Format("Jan 2 15:04")
+			fmt.Fprintf(&text, "- %s since %s: %s", streamer.DisplayName, started, session.Title)
+			fmt.Fprintf(&html, "<li>%s since %s: %s", htmlChannelLink(streamer), started, escapeHTML(session.Title))
+			if session.GameName != "" {
+				fmt.Fprintf(&text, " (%s)", session.GameName)
+				fmt.Fprintf(&html, " (%s)", htmlItalic(session.GameName))
+			}
+			text.WriteString("\n")
+			html.WriteString("</li>\n")
+		}
+		html.WriteString("</ul>\n")
+	}
+
+	return EmailMessage{
+		ID:      "digest-" + day.Format("20060102"),
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}
+}
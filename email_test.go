@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestNextDigestTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading time zone: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		offset time.Duration
+		want   time.Time
+	}{
+		{"later today", time.Date(2026, 6, 1, 6, 0, 0, 0, newYork), 8 * time.Hour, time.Date(2026, 6, 1, 8, 0, 0, 0, newYork)},
+		{"tomorrow", time.Date(2026, 6, 1, 8, 0, 0, 0, newYork), 8 * time.Hour, time.Date(2026, 6, 2, 8, 0, 0, 0, newYork)},
+		{"minutes", time.Date(2026, 6, 1, 6, 0, 0, 0, newYork), 7*time.Hour + 30*time.Minute, time.Date(2026, 6, 1, 7, 30, 0, 0, newYork)},
+		{"spring forward", time.Date(2026, 3, 8, 0, 30, 0, 0, newYork), 8 * time.Hour, time.Date(2026, 3, 8, 8, 0, 0, 0, newYork)},
+		{"fall back", time.Date(2026, 11, 1, 0, 30, 0, 0, newYork), 8 * time.Hour, time.Date(2026, 11, 1, 8, 0, 0, 0, newYork)},
+		{"across spring forward", time.Date(2026, 3, 7, 9, 0, 0, 0, newYork), 8 * time.Hour, time.Date(2026, 3, 8, 8, 0, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDigestTime(tt.now, tt.offset); !got.Equal(tt.want) {
+				t.Errorf("nextDigestTime(%v, %v) = %v, want %v", tt.now, tt.offset, got, tt.want)
+			}
+		})
+	}
+}
+
+// smtpStub is a single-connection SMTP server offering STARTTLS and, once
+// the connection is encrypted, AUTH PLAIN. It records what the client sent.
+type smtpStub struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	done      chan struct{}
+
+	commands []string
+	auth     string
+	authTLS  bool
+	from     string
+	to       []string
+	data     []byte
+	err      error
+}
+
+func newSMTPStub(t *testing.T) (*smtpStub, *tls.Config) {
+	// Borrow the certificate of an HTTPS test server, valid for 127.0.0.1
+	httpsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(httpsServer.Close)
+	clientTLS := &tls.Config{
+		ServerName: "127.0.0.1",
+		RootCAs:    httpsServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	stub := &smtpStub{listener: listener, tlsConfig: httpsServer.TLS, done: make(chan struct{})}
+	go stub.serve()
+	return stub, clientTLS
+}
+
+func (stub *smtpStub) port() int {
+	return stub.listener.Addr().(*net.TCPAddr).Port
+}
+
+// wait returns once the client disconnected, failing the test if the
+// conversation went wrong.
+func (stub *smtpStub) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-stub.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP conversation timed out")
+	}
+	if stub.err != nil {
+		t.Fatalf("SMTP stub: %v", stub.err)
+	}
+}
+
+func (stub *smtpStub) serve() {
+	defer close(stub.done)
+	conn, err := stub.listener.Accept()
+	if err != nil {
+		stub.err = err
+		return
+	}
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	text := textproto.NewConn(conn)
+	encrypted := false
+	reply := func(format string, args ...any) { text.PrintfLine(format, args...) }
+	reply("220 stub ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			stub.err = err
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		stub.commands = append(stub.commands, verb)
+
+		switch verb {
+		case "EHLO":
+			if encrypted {
+				reply("250-stub")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250-stub")
+				reply("250 STARTTLS")
+			}
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, stub.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				stub.err = err
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			encrypted = true
+		case "AUTH":
+			mechanism, response, _ := strings.Cut(arg, " ")
+			credentials, err := base64.StdEncoding.DecodeString(response)
+			if mechanism != "PLAIN" || err != nil {
+				reply("504 unsupported")
+				continue
+			}
+			stub.auth = string(credentials)
+			stub.authTLS = encrypted
+			reply("235 authenticated")
+		case "MAIL":
+			stub.from = strings.TrimSuffix(strings.TrimPrefix(arg, "FROM:<"), ">")
+			reply("250 ok")
+		case "RCPT":
+			stub.to = append(stub.to, strings.TrimSuffix(strings.TrimPrefix(arg, "TO:<"), ">"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			if stub.data, err = text.ReadDotBytes(); err != nil {
+				stub.err = err
+				return
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func newTestEmailNotifier(stub *smtpStub, clientTLS *tls.Config) *EmailNotifier {
+	return &EmailNotifier{
+		ctx:       context.Background(),
+		host:      "127.0.0.1",
+		port:      stub.port(),
+		username:  "bot",
+		password:  "hunter2",
+		from:      "TGTping <bot@example.org>",
+		to:        []string{"alice@example.org", "Bob <bob@example.org>"},
+		security:  SMTPSecurityStartTLS,
+		tlsConfig: clientTLS,
+	}
+}
+
+// readMail parses a mail sent to the stub and returns its subject and the
+// decoded plain-text and HTML alternatives.
+func readMail(t *testing.T, data []byte) (string, string, string) {
+	t.Helper()
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid mail: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("invalid subject: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", message.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	var contents []string
+	var types []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid part: %v", err)
+		}
+		// The reader decodes quoted-printable parts
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+		contents = append(contents, string(content))
+	}
+	if len(contents) != 2 || types[0] != "text/plain; charset=utf-8" || types[1] != "text/html; charset=utf-8" {
+		t.Fatalf("parts = %q, want plain text then HTML", types)
+	}
+	return subject, contents[0], contents[1]
+}
+
+func TestEmailNotifierLive(t *testing.T) {
+	stub, clientTLS := newSMTPStub(t)
+	notifier := newTestEmailNotifier(stub, clientTLS)
+
+	event := &NotificationEvent{
+		ID:          "event1",
+		Username:    "streamer",
+		DisplayName: "Stréamer",
+		Title:       "Speedruns & <chill>",
+		GameName:    "Celeste",
+		ViewerCount: 12,
+	}
+	if _, err := notifier.Live(NotificationTarget{Type: notifierEmail}, event); err != nil {
+		t.Fatalf("Live() error = %v", err)
+	}
+	stub.wait(t)
+
+	if want := "EHLO STARTTLS EHLO AUTH MAIL RCPT RCPT DATA QUIT"; strings.Join(stub.commands, " ") != want {
+		t.Errorf("commands = %q, want %q", strings.Join(stub.commands, " "), want)
+	}
+	if !stub.authTLS || stub.auth != "\x00bot\x00hunter2" {
+		t.Errorf("auth = %q (encrypted %v), want PLAIN bot/hunter2 after STARTTLS", stub.auth, stub.authTLS)
+	}
+	if stub.from != "bot@example.org" || strings.Join(stub.to, ",") != "alice@example.org,bob@example.org" {
+		t.Errorf("envelope from %q to %q", stub.from, stub.to)
+	}
+
+	subject, text, html := readMail(t, stub.data)
+	if subject != "Stréamer is now live!" {
+		t.Errorf("subject = %q", subject)
+	}
+	if want := "Speedruns & <chill>\n🎮 Celeste\n👥 12 viewers\n\nhttps://twitch.tv/streamer\n"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if !strings.Contains(html, "<p>Speedruns &amp; &lt;chill&gt;</p>") || !strings.Contains(html, `href="https://twitch.tv/streamer"`) {
+		t.Errorf("html = %q", html)
+	}
+}
+
+func TestEmailNotifierDigest(t *testing.T) {
+	stub, clientTLS := newSMTPStub(t)
+	notifier := newTestEmailNotifier(stub, clientTLS)
+
+	day := time.Date(2026, 6, 1, 8, 0, 0, 0, time.Local)
+	records := []SessionRecord{{
+		Username:    "speedy",
+		DisplayName: "Speedy",
+		StartedAt:   day.Add(2 * time.Hour),
+		EndedAt:     day.Add(5*time.Hour + 30*time.Minute),
+		Games:       []string{"Celeste", "Hades"},
+		PeakViewers: 321,
+	}}
+	live := []*Streamer{{
+		Username:    "night_owl",
+		DisplayName: "NightOwl",
+		Session:     &StreamSession{StartedAt: day.Add(20 * time.Hour), Title: "Late & live", GameName: "Chess"},
+	}}
+	if err := notifier.send(newDigestEmail(day, records, live)); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	stub.wait(t)
+
+	subject, text, html := readMail(t, stub.data)
+	if subject != "Twitch digest for Mon Jun 1: 1 stream" {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{
+		"Streams:\n- Speedy: Jun 1 10:00 for 3h 30m, Celeste, Hades, peak 321 viewers\n",
+		"Live now:\n- NightOwl since Jun 2 04:00: Late & live (Chess)\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text = %q, want it to contain %q", text, want)
+		}
+	}
+	for _, want := range []string{
+		"<td>Celeste, Hades</td><td>321</td>",
+		"Late &amp; live (<i>Chess</i>)",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html = %q, want it to contain %q", html, want)
+		}
+	}
+}
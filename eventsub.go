@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var eventSubStreamTypes = []string{"stream.online", "stream.offline"}
+
+func (app *App) startEventSub() {
+	if app.config.TwitchUserToken == "" {
+		log.Println("TWITCH_USER_TOKEN not set, EventSub disabled (polling only)")
+		return
+	}
+
+	app.eventSub = &EventSubClient{
+		subscriptions: make(map[string][]string),
+	}
+
+	go func() {
+		<-app.ctx.Done()
+		app.eventSub.closeConn()
+	}()
+
+	go app.runEventSub()
+}
+
+func (app *App) runEventSub() {
+	log.Println("Starting EventSub WebSocket client")
+	backoff := time.Second
+
+	for {
+		welcomed, err := app.runEventSubConnection(EventSubWebSocketURL)
+		app.eventSub.reset()
+
+		select {
+		case <-app.ctx.Done():
+			log.Println("EventSub client stopping")
+			return
+		default:
+		}
+
+		// A session that got going resets the backoff, so a drop after hours
+		// of service doesn't wait as long as repeated failures to connect
+		if welcomed {
+			backoff = time.Second
+		}
+
+		log.Printf("EventSub connection lost: %v (reconnecting in %v, polling all streamers meanwhile)", err, backoff)
+		select {
+		case <-app.ctx.Done():
+			log.Println("EventSub client stopping")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > EventSubMaxBackoff {
+			backoff = EventSubMaxBackoff
+		}
+	}
+}
+
+// runEventSubConnection serves a WebSocket session until it fails, and
+// reports whether a session was established. On session_reconnect the old
+// connection is kept open, and its notifications handled, until the new one
+// is welcomed, as Twitch may still deliver events on it in the meantime.
+func (app *App) runEventSubConnection(url string) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(app.ctx, url, nil)
+	if err != nil {
+		return false, err
+	}
+	app.eventSub.setConn(conn)
+	defer app.eventSub.closeConn()
+
+	var timeout atomic.Int64
+	timeout.Store(int64(10*time.Second + 5*time.Second))
+	frames := make(chan eventSubFrame)
+	done := make(chan struct{})
+	defer close(done)
+	go readEventSubFrames(conn, &timeout, frames, done)
+
+	// Connection being replaced after a session_reconnect
+	var old *websocket.Conn
+	defer func() {
+		if old != nil {
+			old.Close()
+		}
+	}()
+
+	welcomed := false
+	reconnecting := false
+
+	for {
+		frame := <-frames
+		// Connections already replaced are closed by now, their last frames
+		// (errors included) are left over
+		if frame.conn != conn && frame.conn != old {
+			continue
+		}
+		if frame.err != nil {
+			if frame.conn == old {
+				// Twitch closes the old connection once the new one is up
+				old = nil
+				continue
+			}
+			return welcomed, frame.err
+		}
+
+		msg := frame.msg
+		switch msg.Metadata.MessageType {
+		case "session_welcome":
+			session := msg.Payload.Session
+			if session == nil {
+				return welcomed, fmt.Errorf("welcome message without session")
+			}
+			if session.KeepaliveTimeoutSeconds > 0 {
+				timeout.Store(int64(time.Duration(session.KeepaliveTimeoutSeconds)*time.Second + 5*time.Second))
+			}
+			app.handleEventSubWelcome(session, !reconnecting)
+			welcomed = true
+			reconnecting = false
+			if old != nil {
+				old.Close()
+				old = nil
+			}
+		case "session_keepalive":
+		case "notification":
+			go app.handleEventSubNotification(msg.Metadata.SubscriptionType, msg.Payload.Event)
+		case "session_reconnect":
+			session := msg.Payload.Session
+			if session == nil || session.ReconnectURL == "" {
+				return welcomed, fmt.Errorf("reconnect message without reconnect URL")
+			}
+			log.Println("EventSub server requested reconnect")
+
+			newConn, _, err := websocket.DefaultDialer.DialContext(app.ctx, session.ReconnectURL, nil)
+			if err != nil {
+				return welcomed, fmt.Errorf("failed to follow reconnect URL: %v", err)
+			}
+			go readEventSubFrames(newConn, &timeout, frames, done)
+			if old != nil {
+				old.Close()
+			}
+			old = conn
+			conn = newConn
+			app.eventSub.setConn(conn)
+			reconnecting = true
+		case "revocation":
+			app.handleEventSubRevocation(msg.Payload.Subscription)
+		default:
+			log.Printf("Ignoring unknown EventSub message type: %s", msg.Metadata.MessageType)
+		}
+	}
+}
+
+// readEventSubFrames forwards the messages of a connection until reading
+// fails, the error being forwarded last. A read times out when no message,
+// keepalives included, arrives within timeout.
+func readEventSubFrames(conn *websocket.Conn, timeout *atomic.Int64, frames chan<- eventSubFrame, done <-chan struct{}) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(timeout.Load())))
+
+		var msg EventSubMessage
+		err := conn.ReadJSON(&msg)
+		select {
+		case frames <- eventSubFrame{conn: conn, msg: msg, err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (app *App) handleEventSubWelcome(session *EventSubSession, fresh bool) {
+	app.eventSub.mutex.Lock()
+	app.eventSub.sessionID = session.ID
+	app.eventSub.connected = true
+	if fresh {
+		app.eventSub.subscriptions = make(map[string][]string)
+	}
+	app.eventSub.mutex.Unlock()
+
+	if !fresh {
+		log.Printf("EventSub reconnected (session %s)", session.ID)
+		return
+	}
+
+	log.Printf("EventSub connected (session %s)", session.ID)
+	go func() {
+		for _, streamer := range app.streamerManager.getStreamers() {
+			app.subscribeStreamerWebSocketEvents(streamer)
+		}
+
+		// Catch up on transitions missed while the socket was down
+		if err := app.pollStreamers(app.streamerManager.getStreamers()); err != nil {
+			log.Printf("Error reconciling stream status: %v", err)
+		}
+	}()
+}
+
+func (app *App) handleEventSubNotification(subscriptionType string, payload json.RawMessage) {
+	var event EventSubStreamEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("Error unmarshalling EventSub %s event: %v", subscriptionType, err)
+		return
+	}
+
+	streamer := app.findStreamerByUserID(event.BroadcasterUserID)
+	if streamer == nil {
+		log.Printf("Ignoring EventSub %s event for untracked broadcaster %s", subscriptionType, event.BroadcasterUserLogin)
+		return
+	}
+
+	var streamData *TwitchStreamData
+	switch subscriptionType {
+	case "stream.online":
+		streamData = app.getStreamDataForEvent(&event)
+	case "stream.offline":
+	default:
+		log.Printf("Ignoring unknown EventSub subscription type: %s", subscriptionType)
+		return
+	}
+
+	if err := app.checkAndUpdateStreamerStatus(streamer, streamData, true); err != nil {
+		log.Printf("Error updating streamer status for %s: %v", streamer.Username, err)
+	}
+}
+
+// getStreamDataForEvent fetches the full stream details for an online event.
+// Helix can lag behind EventSub by a few seconds, so it retries before falling
+// back to the minimal data carried by the event itself.
+func (app *App) getStreamDataForEvent(event *EventSubStreamEvent) *TwitchStreamData {
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-app.ctx.Done():
+				attempt = 3
+				continue
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		streamInfo, err := app.getStreamInfo(event.BroadcasterUserID)
+		if err != nil {
+			log.Printf("Error fetching stream info for %s: %v", event.BroadcasterUserLogin, err)
+			continue
+		}
+		if streamInfo != nil && len(streamInfo.Data) > 0 {
+			return &streamInfo.Data[0]
+		}
+	}
+
+	return &TwitchStreamData{
+		ID:        event.ID,
+		UserID:    event.BroadcasterUserID,
+		UserLogin: event.BroadcasterUserLogin,
+		UserName:  event.BroadcasterUserName,
+		StartedAt: event.StartedAt,
+	}
+}
+
+func (app *App) handleEventSubRevocation(subscription *EventSubSubscription) {
+	if subscription == nil {
+		return
+	}
+
+	log.Printf("EventSub subscription %s (%s) for %s revoked: %s (falling back to polling)",
+		subscription.ID, subscription.Type, subscription.Condition.BroadcasterUserID, subscription.Status)
+	app.eventSub.removeSubscription(subscription.Condition.BroadcasterUserID, subscription.ID)
+}
+
+func (app *App) subscribeStreamerEvents(streamer *Streamer) {
+	app.subscribeStreamerWebSocketEvents(streamer)
+	app.subscribeStreamerWebhookEvents(streamer)
+}
+
+func (app *App) unsubscribeStreamerEvents(userID string) {
+	app.unsubscribeStreamerWebSocketEvents(userID)
+	app.unsubscribeStreamerWebhookEvents(userID)
+}
+
+func (app *App) subscribeStreamerWebSocketEvents(streamer *Streamer) {
+	if app.eventSub == nil {
+		return
+	}
+
+	sessionID, connected := app.eventSub.session()
+	if !connected {
+		return
+	}
+
+	for _, subscriptionType := range eventSubStreamTypes {
+		subscription, err := app.createEventSubSubscription(subscriptionType, streamer.UserID, sessionID)
+		if err != nil {
+			log.Printf("Error subscribing to %s for %s (falling back to polling): %v", subscriptionType, streamer.Username, err)
+			continue
+		}
+		app.eventSub.addSubscription(streamer.UserID, subscription.ID)
+	}
+}
+
+func (app *App) unsubscribeStreamerWebSocketEvents(userID string) {
+	if app.eventSub == nil {
+		return
+	}
+
+	for _, id := range app.eventSub.takeSubscriptions(userID) {
+		if err := app.deleteEventSubSubscription(id, app.config.TwitchUserToken); err != nil {
+			log.Printf("Error deleting EventSub subscription %s: %v", id, err)
+		}
+	}
+}
+
+func (app *App) isCoveredByEventSub(userID string) bool {
+	return app.isCoveredByEventSubWebSocket(userID) || app.isCoveredByEventSubWebhook(userID)
+}
+
+func (app *App) isCoveredByEventSubWebSocket(userID string) bool {
+	if app.eventSub == nil {
+		return false
+	}
+
+	app.eventSub.mutex.Lock()
+	defer app.eventSub.mutex.Unlock()
+	return app.eventSub.connected && len(app.eventSub.subscriptions[userID]) == len(eventSubStreamTypes)
+}
+
+func (app *App) createEventSubSubscription(subscriptionType, userID, sessionID string) (*EventSubSubscription, error) {
+	subscription := EventSubSubscription{
+		Type:      subscriptionType,
+		Version:   "1",
+		Condition: EventSubCondition{BroadcasterUserID: userID},
+		Transport: EventSubTransport{Method: "websocket", SessionID: sessionID},
+	}
+
+	return app.postEventSubSubscription(&subscription, app.config.TwitchUserToken)
+}
+
+func (app *App) postEventSubSubscription(subscription *EventSubSubscription, token string) (*EventSubSubscription, error) {
+	body, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := app.makeTwitchAPIRequestWithToken(app.ctx, "POST", "https://api.twitch.tv/helix/eventsub/subscriptions", token, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("subscription request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var subResp EventSubSubscriptionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&subResp); err != nil {
+		return nil, err
+	}
+	if len(subResp.Data) == 0 {
+		return nil, fmt.Errorf("empty subscription response")
+	}
+
+	return &subResp.Data[0], nil
+}
+
+func (app *App) listEventSubSubscriptions(subscriptionType string) ([]EventSubSubscription, error) {
+	var subscriptions []EventSubSubscription
+	cursor := ""
+
+	for {
+		url := "https://api.twitch.tv/helix/eventsub/subscriptions?type=" + subscriptionType
+		if cursor != "" {
+			url += "&after=" + cursor
+		}
+
+		var subResp EventSubSubscriptionResponse
+		if err := app.callTwitchAPI(url, &subResp); err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subResp.Data...)
+		if subResp.Pagination.Cursor == "" {
+			return subscriptions, nil
+		}
+		cursor = subResp.Pagination.Cursor
+	}
+}
+
+func (app *App) deleteEventSubSubscription(id, token string) error {
+	resp, err := app.makeTwitchAPIRequestWithToken(app.ctx, "DELETE", "https://api.twitch.tv/helix/eventsub/subscriptions?id="+id, token, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+func (es *EventSubClient) setConn(conn *websocket.Conn) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	es.conn = conn
+}
+
+func (es *EventSubClient) closeConn() {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if es.conn != nil {
+		es.conn.Close()
+		es.conn = nil
+	}
+}
+
+func (es *EventSubClient) reset() {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	es.sessionID = ""
+	es.connected = false
+	es.subscriptions = make(map[string][]string)
+}
+
+func (es *EventSubClient) session() (string, bool) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	return es.sessionID, es.connected
+}
+
+func (es *EventSubClient) addSubscription(userID, id string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	es.subscriptions[userID] = append(es.subscriptions[userID], id)
+}
+
+func (es *EventSubClient) removeSubscription(userID, id string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	ids := es.subscriptions[userID]
+	for i, existing := range ids {
+		if existing == id {
+			es.subscriptions[userID] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+}
+
+func (es *EventSubClient) takeSubscriptions(userID string) []string {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	ids := es.subscriptions[userID]
+	delete(es.subscriptions, userID)
+	return ids
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestApp returns an app backed by a JSON store in a temporary directory,
+// without Telegram or Twitch clients.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	store, err := NewJSONStore(filepath.Join(t.TempDir(), "streamers.json"), 0)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	streamerManager, err := NewStreamerManager(store)
+	if err != nil {
+		t.Fatalf("NewStreamerManager() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &App{
+		streamerManager: streamerManager,
+		ctx:             ctx,
+		cancel:          cancel,
+		outboxWake:      make(chan struct{}, 1),
+		metrics:         newMetrics(),
+		startedAt:       time.Now(),
+		deadLetterPath:  filepath.Join(t.TempDir(), "dead_letters.jsonl"),
+	}
+}
+
+func writeEventSubMessage(t *testing.T, conn *websocket.Conn, messageType string, payload EventSubPayload, subscriptionType string) {
+	t.Helper()
+	message := EventSubMessage{
+		Metadata: EventSubMetadata{MessageType: messageType, SubscriptionType: subscriptionType},
+		Payload:  payload,
+	}
+	if err := conn.WriteJSON(message); err != nil {
+		t.Errorf("writing %s: %v", messageType, err)
+	}
+}
+
+func TestRunEventSubConnectionReconnect(t *testing.T) {
+	app := newTestApp(t)
+	app.eventSub = &EventSubClient{subscriptions: make(map[string][]string)}
+
+	upgrader := websocket.Upgrader{}
+	oldClosed := make(chan struct{})
+	notify := make(chan struct{})
+	finish := make(chan struct{})
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		writeEventSubMessage(t, conn, "session_welcome", EventSubPayload{Session: &EventSubSession{ID: "first", KeepaliveTimeoutSeconds: 10}}, "")
+		writeEventSubMessage(t, conn, "session_reconnect", EventSubPayload{Session: &EventSubSession{ID: "first", ReconnectURL: wsURL + "/reconnect"}}, "")
+
+		// The client hangs up once the new connection is welcomed
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(oldClosed)
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/reconnect", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		writeEventSubMessage(t, conn, "session_welcome", EventSubPayload{Session: &EventSubSession{ID: "second", KeepaliveTimeoutSeconds: 10}}, "")
+		<-notify
+		writeEventSubMessage(t, conn, "notification", EventSubPayload{Event: []byte(`{"broadcaster_user_id":"42","broadcaster_user_login":"streamer"}`)}, "stream.offline")
+		<-finish
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	})
+
+	type result struct {
+		welcomed bool
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		welcomed, err := app.runEventSubConnection(wsURL + "/ws")
+		results <- result{welcomed, err}
+	}()
+
+	select {
+	case <-oldClosed:
+	case res := <-results:
+		t.Fatalf("connection ended during the reconnect: %v", res.err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("old connection was never closed")
+	}
+
+	// Leftover frames of the old connection must not end the session
+	select {
+	case res := <-results:
+		t.Fatalf("connection ended after the reconnect: %v", res.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if sessionID, connected := app.eventSub.session(); sessionID != "second" || !connected {
+		t.Fatalf("session = %q (connected %v), want second", sessionID, connected)
+	}
+
+	// Notifications on the new connection are handled
+	streamer := &Streamer{
+		Username:    "streamer",
+		DisplayName: "Streamer",
+		UserID:      "42",
+		IsLive:      true,
+		Session:     &StreamSession{StartedAt: time.Now().Add(-time.Hour)},
+	}
+	if err := app.streamerManager.addStreamer(streamer); err != nil {
+		t.Fatalf("addStreamer() error = %v", err)
+	}
+	close(notify)
+	deadline := time.Now().Add(5 * time.Second)
+	for app.streamerManager.getSession("42") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("stream.offline notification on the new connection wasn't handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(finish)
+	select {
+	case res := <-results:
+		if !res.welcomed {
+			t.Error("welcomed = false, want true")
+		}
+		if !websocket.IsCloseError(res.err, websocket.CloseNormalClosure) {
+			t.Errorf("error = %v, want the close of the new connection", res.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection didn't end when the new socket closed")
+	}
+}
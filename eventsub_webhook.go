@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+func (app *App) startEventSubWebhook() {
+	if app.config.WebhookCallbackURL == "" || app.config.WebhookSecret == "" {
+		log.Println("EVENTSUB_WEBHOOK_CALLBACK_URL or EVENTSUB_WEBHOOK_SECRET not set, EventSub webhooks disabled")
+		return
+	}
+
+	// Checked by validateWebhookCallbackURL when loading the configuration
+	callbackURL, _ := url.Parse(app.config.WebhookCallbackURL)
+	callbackPath := callbackURL.Path
+
+	app.webhook = &EventSubWebhook{
+		subscriptions: make(map[string][]string),
+		pending:       make(map[string]string),
+		seenMessages:  make(map[string]time.Time),
+	}
+	app.httpMux.HandleFunc(callbackPath, app.handleEventSubWebhook)
+	log.Printf("EventSub webhook receiver listening on %s", callbackPath)
+
+	go app.syncWebhookSubscriptions()
+}
+
+// validateWebhookCallbackURL checks EVENTSUB_WEBHOOK_CALLBACK_URL: an https
+// URL whose path can be served next to the other routes, so neither "/" (which
+// would catch every request) nor a reserved route, whose duplicate
+// registration would panic.
+func validateWebhookCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("expected an https:// URL")
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		return fmt.Errorf("the URL needs a path, e.g. https://%s/eventsub", parsed.Host)
+	}
+	if slices.Contains(reservedHTTPPaths, parsed.Path) {
+		return fmt.Errorf("path %s is already served by the bot", parsed.Path)
+	}
+	if strings.ContainsAny(parsed.Path, "{}") {
+		return fmt.Errorf("path %s can't contain braces", parsed.Path)
+	}
+	return nil
+}
+
+// syncWebhookSubscriptions reconciles the subscriptions registered with Twitch
+// for our callback against the tracked streamers: stale or failed ones are
+// deleted and missing ones are created.
+func (app *App) syncWebhookSubscriptions() {
+	tracked := make(map[string]*Streamer)
+	for _, streamer := range app.streamerManager.getStreamers() {
+		tracked[streamer.UserID] = streamer
+	}
+
+	existing := make(map[string]map[string]bool)
+	for _, subscriptionType := range eventSubStreamTypes {
+		subscriptions, err := app.listEventSubSubscriptions(subscriptionType)
+		if err != nil {
+			log.Printf("Error listing EventSub %s subscriptions: %v", subscriptionType, err)
+			return
+		}
+
+		for _, subscription := range subscriptions {
+			if subscription.Transport.Method != "webhook" || subscription.Transport.Callback != app.config.WebhookCallbackURL {
+				continue
+			}
+
+			userID := subscription.Condition.BroadcasterUserID
+			usable := subscription.Status == "enabled" || subscription.Status == "webhook_callback_verification_pending"
+			if tracked[userID] == nil || !usable {
+				if err := app.deleteEventSubSubscription(subscription.ID, app.currentTwitchToken()); err != nil {
+					log.Printf("Error deleting EventSub subscription %s: %v", subscription.ID, err)
+				}
+				continue
+			}
+
+			if existing[userID] == nil {
+				existing[userID] = make(map[string]bool)
+			}
+			existing[userID][subscription.Type] = true
+			app.webhook.trackSubscription(userID, subscription.ID, subscription.Status == "enabled")
+		}
+	}
+
+	for userID, streamer := range tracked {
+		for _, subscriptionType := range eventSubStreamTypes {
+			if existing[userID][subscriptionType] {
+				continue
+			}
+			app.createWebhookSubscription(subscriptionType, streamer)
+		}
+	}
+}
+
+func (app *App) subscribeStreamerWebhookEvents(streamer *Streamer) {
+	if app.webhook == nil {
+		return
+	}
+
+	for _, subscriptionType := range eventSubStreamTypes {
+		app.createWebhookSubscription(subscriptionType, streamer)
+	}
+}
+
+func (app *App) createWebhookSubscription(subscriptionType string, streamer *Streamer) {
+	if err := app.getTwitchToken(); err != nil {
+		log.Printf("Error getting Twitch token for %s subscription: %v", subscriptionType, err)
+		return
+	}
+
+	subscription, err := app.postEventSubSubscription(&EventSubSubscription{
+		Type:      subscriptionType,
+		Version:   "1",
+		Condition: EventSubCondition{BroadcasterUserID: streamer.UserID},
+		Transport: EventSubTransport{
+			Method:   "webhook",
+			Callback: app.config.WebhookCallbackURL,
+			Secret:   app.config.WebhookSecret,
+		},
+	}, app.currentTwitchToken())
+	if err != nil {
+		log.Printf("Error subscribing to %s for %s (falling back to polling): %v", subscriptionType, streamer.Username, err)
+		return
+	}
+
+	app.webhook.trackSubscription(streamer.UserID, subscription.ID, subscription.Status == "enabled")
+}
+
+func (app *App) unsubscribeStreamerWebhookEvents(userID string) {
+	if app.webhook == nil {
+		return
+	}
+
+	if err := app.getTwitchToken(); err != nil {
+		log.Printf("Error getting Twitch token to delete subscriptions: %v", err)
+		return
+	}
+
+	for _, id := range app.webhook.takeSubscriptions(userID) {
+		if err := app.deleteEventSubSubscription(id, app.currentTwitchToken()); err != nil {
+			log.Printf("Error deleting EventSub subscription %s: %v", id, err)
+		}
+	}
+}
+
+func (app *App) isCoveredByEventSubWebhook(userID string) bool {
+	if app.webhook == nil {
+		return false
+	}
+
+	app.webhook.mutex.Lock()
+	defer app.webhook.mutex.Unlock()
+	return len(app.webhook.subscriptions[userID]) == len(eventSubStreamTypes)
+}
+
+func (app *App) handleEventSubWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	messageID := r.Header.Get("Twitch-Eventsub-Message-Id")
+	timestamp := r.Header.Get("Twitch-Eventsub-Message-Timestamp")
+	signature := r.Header.Get("Twitch-Eventsub-Message-Signature")
+
+	if !verifyEventSubSignature(app.config.WebhookSecret, messageID, timestamp, body, signature) {
+		log.Printf("Rejected EventSub webhook with invalid signature (message %s)", messageID)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	// Messages from the future are rejected too, or they would outlive their
+	// entry in the replay cache
+	sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if age := time.Since(sentAt); err != nil || age > WebhookMaxMessageAge || age < -WebhookMaxClockSkew {
+		log.Printf("Rejected stale EventSub webhook (message %s, timestamp %s)", messageID, timestamp)
+		http.Error(w, "stale message", http.StatusForbidden)
+		return
+	}
+
+	if !app.webhook.markSeen(messageID, sentAt) {
+		log.Printf("Ignoring replayed EventSub webhook (message %s)", messageID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var payload EventSubWebhookBody
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Subscription == nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Header.Get("Twitch-Eventsub-Message-Type") {
+	case "webhook_callback_verification":
+		log.Printf("Verified EventSub %s subscription for %s", payload.Subscription.Type, payload.Subscription.Condition.BroadcasterUserID)
+		app.webhook.trackSubscription(payload.Subscription.Condition.BroadcasterUserID, payload.Subscription.ID, true)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, payload.Challenge)
+	case "notification":
+		w.WriteHeader(http.StatusNoContent)
+		go app.handleEventSubNotification(payload.Subscription.Type, payload.Event)
+	case "revocation":
+		log.Printf("EventSub subscription %s (%s) for %s revoked: %s (falling back to polling)",
+			payload.Subscription.ID, payload.Subscription.Type, payload.Subscription.Condition.BroadcasterUserID, payload.Subscription.Status)
+		app.webhook.removeSubscription(payload.Subscription.Condition.BroadcasterUserID, payload.Subscription.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func verifyEventSubSignature(secret, messageID, timestamp string, body []byte, signature string) bool {
+	if messageID == "" || timestamp == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (wh *EventSubWebhook) trackSubscription(userID, id string, enabled bool) {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	for _, existing := range wh.subscriptions[userID] {
+		if existing == id {
+			return
+		}
+	}
+
+	if !enabled {
+		wh.pending[id] = userID
+		return
+	}
+
+	delete(wh.pending, id)
+	wh.subscriptions[userID] = append(wh.subscriptions[userID], id)
+}
+
+func (wh *EventSubWebhook) removeSubscription(userID, id string) {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	delete(wh.pending, id)
+	ids := wh.subscriptions[userID]
+	for i, existing := range ids {
+		if existing == id {
+			wh.subscriptions[userID] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+}
+
+func (wh *EventSubWebhook) takeSubscriptions(userID string) []string {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	ids := wh.subscriptions[userID]
+	delete(wh.subscriptions, userID)
+	for id, pendingUserID := range wh.pending {
+		if pendingUserID == userID {
+			ids = append(ids, id)
+			delete(wh.pending, id)
+		}
+	}
+	return ids
+}
+
+// markSeen records a message ID and reports whether it was new. Entries are
+// pruned once their message is older than the accepted message age, since
+// replays of them are rejected by the timestamp check from then on.
+func (wh *EventSubWebhook) markSeen(messageID string, sentAt time.Time) bool {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	now := time.Now()
+	for id, seenSentAt := range wh.seenMessages {
+		if now.Sub(seenSentAt) > WebhookMaxMessageAge {
+			delete(wh.seenMessages, id)
+		}
+	}
+
+	if _, seen := wh.seenMessages[messageID]; seen {
+		return false
+	}
+	wh.seenMessages[messageID] = sentAt
+	return true
+}
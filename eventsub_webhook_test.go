@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateWebhookCallbackURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://bot.example.com/eventsub", false},
+		{"https://bot.example.com/twitch/callback", false},
+		{"http://bot.example.com/eventsub", true},
+		{"https://bot.example.com", true},
+		{"https://bot.example.com/", true},
+		{"https://bot.example.com/metrics", true},
+		{"https://bot.example.com/healthz", true},
+		{"https://bot.example.com/readyz", true},
+		{"https://bot.example.com/%7Bid%7D", true},
+	}
+
+	for _, tt := range tests {
+		err := validateWebhookCallbackURL(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateWebhookCallbackURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func signEventSubMessage(secret, messageID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID + timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyEventSubSignature(t *testing.T) {
+	body := []byte(`{"event":{}}`)
+	timestamp := "2026-01-01T12:00:00.123456789Z"
+	valid := signEventSubMessage("secret", "message1", timestamp, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		messageID string
+		timestamp string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", "secret", "message1", timestamp, body, valid, true},
+		{"wrong secret", "other", "message1", timestamp, body, valid, false},
+		{"other message ID", "secret", "message2", timestamp, body, valid, false},
+		{"other timestamp", "secret", "message1", "2026-01-01T12:00:01Z", body, valid, false},
+		{"tampered body", "secret", "message1", timestamp, []byte(`{"event":{"x":1}}`), valid, false},
+		{"missing prefix", "secret", "message1", timestamp, body, valid[len("sha256="):], false},
+		{"missing signature", "secret", "message1", timestamp, body, "", false},
+		{"missing message ID", "secret", "", timestamp, body, signEventSubMessage("secret", "", timestamp, body), false},
+		{"missing timestamp", "secret", "message1", "", body, signEventSubMessage("secret", "message1", "", body), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyEventSubSignature(tt.secret, tt.messageID, tt.timestamp, tt.body, tt.signature); got != tt.want {
+				t.Errorf("verifyEventSubSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventSubWebhook(t *testing.T) {
+	body := []byte(`{"challenge":"pong","subscription":{"id":"sub1","type":"stream.online","version":"1","condition":{"broadcaster_user_id":"42"},"transport":{"method":"webhook"}}}`)
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name       string
+		messageID  string
+		sentAt     time.Time
+		signature  string
+		wantStatus int
+	}{
+		{"verification", "message1", now, "", http.StatusOK},
+		{"duplicate message ID", "message1", now, "", http.StatusNoContent},
+		{"invalid signature", "message2", now, "sha256=00", http.StatusForbidden},
+		{"stale", "message3", now.Add(-WebhookMaxMessageAge - time.Minute), "", http.StatusForbidden},
+		{"slightly ahead", "message4", now.Add(WebhookMaxClockSkew / 2), "", http.StatusOK},
+		{"future", "message5", now.Add(WebhookMaxClockSkew + time.Minute), "", http.StatusForbidden},
+	}
+
+	app := newTestApp(t)
+	app.config.WebhookSecret = "secret"
+	app.webhook = &EventSubWebhook{
+		subscriptions: make(map[string][]string),
+		pending:       make(map[string]string),
+		seenMessages:  make(map[string]time.Time),
+	}
+
+	// Cases run in order: the duplicate replays the verification
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp := tt.sentAt.Format(time.RFC3339Nano)
+			signature := tt.signature
+			if signature == "" {
+				signature = signEventSubMessage("secret", tt.messageID, timestamp, body)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/eventsub", bytes.NewReader(body))
+			req.Header.Set("Twitch-Eventsub-Message-Id", tt.messageID)
+			req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+			req.Header.Set("Twitch-Eventsub-Message-Signature", signature)
+			req.Header.Set("Twitch-Eventsub-Message-Type", "webhook_callback_verification")
+			recorder := httptest.NewRecorder()
+			app.handleEventSubWebhook(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && recorder.Body.String() != "pong" {
+				t.Errorf("body = %q, want the challenge", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestEventSubWebhookMarkSeen(t *testing.T) {
+	wh := &EventSubWebhook{seenMessages: make(map[string]time.Time)}
+	now := time.Now()
+
+	if !wh.markSeen("old", now.Add(-WebhookMaxMessageAge-time.Second)) {
+		t.Fatal("first sight of a message reported as seen")
+	}
+	if !wh.markSeen("future", now.Add(WebhookMaxClockSkew)) {
+		t.Fatal("first sight of a message reported as seen")
+	}
+	if wh.markSeen("future", now.Add(WebhookMaxClockSkew)) {
+		t.Error("replayed message reported as new")
+	}
+
+	// Only the message the timestamp check now rejects is forgotten
+	if _, kept := wh.seenMessages["old"]; kept {
+		t.Error("message older than WebhookMaxMessageAge still cached")
+	}
+	if _, kept := wh.seenMessages["future"]; !kept {
+		t.Error("future-dated message dropped from the cache")
+	}
+}
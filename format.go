@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var telegramHTMLTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "a": true, "code": true, "pre": true,
+	"span": true, "tg-spoiler": true, "blockquote": true, "tg-emoji": true,
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// escapeHTML escapes text for Telegram's HTML parse mode, so titles containing
+// <, > or & can't break the message markup.
+func escapeHTML(text string) string {
+	return html.EscapeString(text)
+}
+
+func htmlBold(text string) string {
+	return "<b>" + escapeHTML(text) + "</b>"
+}
+
+func htmlItalic(text string) string {
+	return "<i>" + escapeHTML(text) + "</i>"
+}
+
+func htmlCode(text string) string {
+	return "<code>" + escapeHTML(text) + "</code>"
+}
+
+func htmlPre(text string) string {
+	return "<pre>" + escapeHTML(text) + "</pre>"
+}
+
+func htmlLink(url, text string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, escapeHTML(url), escapeHTML(text))
+}
+
+func htmlChannelLink(streamer *Streamer) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, escapeHTML("https://twitch.tv/"+streamer.Username), htmlBold(streamer.DisplayName))
+}
+
+// stripHTML turns Telegram HTML back into plain text, used as a last resort
+// when Telegram rejects the markup.
+func stripHTML(text string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+}
+
+// validateTelegramHTML checks that text is well-formed markup using only the
+// tags supported by Telegram's HTML parse mode.
+func validateTelegramHTML(text string) error {
+	decoder := xml.NewDecoder(strings.NewReader("<message>" + text + "</message>"))
+	decoder.Entity = xml.HTMLEntity
+
+	root := true
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid HTML: %v", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root {
+			root = false
+			continue
+		}
+		if !telegramHTMLTags[start.Name.Local] {
+			return fmt.Errorf("unsupported HTML tag <%s>", start.Name.Local)
+		}
+	}
+}
+
+func isParseError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
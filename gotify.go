@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+func (notifier *GotifyNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	title, message := pushText(outboxLive, event)
+	return nil, notifier.push(title, message, notifier.priority, event)
+}
+
+func (notifier *GotifyNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	title, message := pushText(outboxOffline, event)
+	return notifier.push(title, message, gotifyDefaultPriority, event)
+}
+
+func (notifier *GotifyNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	title, message := pushText(outboxUpdate, event)
+	return notifier.push(title, message, gotifyDefaultPriority, event)
+}
+
+// push creates a message whose notification opens the Twitch channel when
+// tapped. See requestJSON for errors.
+func (notifier *GotifyNotifier) push(title, message string, priority int, event *NotificationEvent) error {
+	header := http.Header{}
+	header.Set("X-Gotify-Key", notifier.token)
+	return postJSON(notifier.ctx, notifier.client, notifier.serverURL+"/message", header, GotifyMessage{
+		Title:    title,
+		Message:  message,
+		Priority: priority,
+		Extras: map[string]any{
+			"client::notification": map[string]any{"click": map[string]string{"url": event.channelURL()}},
+		},
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports that the process is alive and serving HTTP.
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// handleReadyz reports whether the bot is doing its job: polling recently
+// succeeded, a Twitch token can be obtained and Telegram updates are being
+// received.
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	components := map[string]ComponentHealth{
+		"polling":      app.pollingHealth(),
+		"twitch_token": app.twitchTokenHealth(),
+		"telegram":     app.telegramHealth(),
+	}
+
+	response := HealthResponse{Status: "ok", Components: components}
+	status := http.StatusOK
+	for _, component := range components {
+		if component.Status != "ok" {
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeHealthResponse(w, status, response)
+}
+
+// pollingHealth fails when no polling cycle succeeded for ReadyPollFactor
+// polling intervals, counting from startup before the first success.
+func (app *App) pollingHealth() ComponentHealth {
+	health := ComponentHealth{Status: "ok"}
+
+	since := app.startedAt
+	if lastPoll := app.metrics.lastSuccessfulPoll.Load(); lastPoll > 0 {
+		since = time.Unix(lastPoll, 0)
+		health.LastSuccess = &since
+	}
+
+	age := time.Since(since)
+	ageSeconds := int64(age.Seconds())
+	health.AgeSeconds = &ageSeconds
+
+	if maxAge := time.Duration(app.config.ReadyPollFactor) * app.config.PollingInterval; age > maxAge {
+		health.Status = "failing"
+		health.Error = "no successful poll within " + maxAge.String()
+	}
+	return health
+}
+
+func (app *App) twitchTokenHealth() ComponentHealth {
+	if err := app.getTwitchToken(); err != nil {
+		return ComponentHealth{Status: "failing", Error: err.Error()}
+	}
+	return ComponentHealth{Status: "ok"}
+}
+
+// telegramHealth fails when getUpdates hasn't succeeded for longer than a
+// long poll, counting from startup before the first success.
+func (app *App) telegramHealth() ComponentHealth {
+	health := ComponentHealth{Status: "ok"}
+
+	since := app.startedAt
+	if lastUpdate := app.metrics.lastTelegramUpdate.Load(); lastUpdate > 0 {
+		since = time.Unix(lastUpdate, 0)
+		health.LastSuccess = &since
+	}
+
+	age := time.Since(since)
+	ageSeconds := int64(age.Seconds())
+	health.AgeSeconds = &ageSeconds
+
+	if maxAge := TelegramUpdatesTimeout + TelegramUpdatesMargin; age > maxAge {
+		health.Status = "failing"
+		health.Error = "no successful getUpdates within " + maxAge.String()
+	}
+	return health
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error writing health response: %v", err)
+	}
+}
@@ -7,11 +7,19 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+
 	config := loadConfig()
 
 	bot, err := tgbotapi.NewBotAPI(config.TelegramBotToken)
@@ -20,7 +28,14 @@ func main() {
 	}
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	streamerManager := NewStreamerManager(StreamersFilePath)
+	store, err := openStore(config)
+	if err != nil {
+		log.Fatal("Failed to open storage: ", err)
+	}
+	streamerManager, err := NewStreamerManager(store)
+	if err != nil {
+		log.Fatal("Failed to load streamers: ", err)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	app := &App{
@@ -29,15 +44,32 @@ func main() {
 		bot:             bot,
 		ctx:             ctx,
 		cancel:          cancel,
-		httpClient:      &http.Client{},
+		httpClient:      &http.Client{Timeout: DefaultHTTPTimeout},
+		rateLimiter:     newRateLimiter("Twitch", TwitchRateLimitDefault, TwitchRateLimitWindow),
+		userRateLimiter: newRateLimiter("Twitch user token", TwitchRateLimitDefault, TwitchRateLimitWindow),
+		twitchBreaker:   newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		telegram:        newTelegramQueue(ctx, bot),
+		outboxWake:      make(chan struct{}, 1),
+		httpMux:         http.NewServeMux(),
+		metrics:         newMetrics(),
+		startedAt:       time.Now(),
+		deadLetterPath:  DeadLetterFilePath,
 	}
 
+	app.notifiers = app.newNotifiers()
+
 	app.initialize()
 	app.waitForShutdown()
 }
 
 func (app *App) initialize() {
+	app.startOutbox()
+	app.startEmailDigest()
 	app.startPollingManager()
+	app.startTokenValidator()
+	app.startEventSub()
+	app.startEventSubWebhook()
+	app.startHTTPServer()
 	go app.handleTelegramUpdates()
 }
 
@@ -49,6 +81,11 @@ func (app *App) waitForShutdown() {
 	log.Println("Shutting down gracefully...")
 	app.cancel()
 	app.stopPollingManager()
+	app.stopHTTPServer()
+
+	if err := app.streamerManager.store.Close(); err != nil {
+		log.Printf("Error closing storage: %v", err)
+	}
 
 	log.Println("Shutdown complete")
 }
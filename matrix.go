@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (notifier *MatrixNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	html := fmt.Sprintf("🔴 %s is now live!", htmlChannelLink(event.streamer()))
+	if event.Title != "" {
+		html += "<br>📺 " + escapeHTML(event.Title)
+	}
+	if event.GameName != "" {
+		html += "<br>🎮 " + htmlItalic(event.GameName)
+	}
+	html += fmt.Sprintf("<br>👥 %d viewers", event.ViewerCount)
+
+	// Messages aren't edited as the stream goes on
+	return nil, notifier.send(target.RoomID, event.ID+"-live", html)
+}
+
+func (notifier *MatrixNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	html := fmt.Sprintf("⚫ %s went offline", htmlChannelLink(event.streamer()))
+	if event.Title != "" {
+		html += "<br>📺 " + escapeHTML(event.Title)
+	}
+	if event.GameName != "" {
+		html += "<br>🎮 " + htmlItalic(event.GameName)
+	}
+	if !event.StartedAt.IsZero() {
+		html += "<br>⏱️ Streamed for " + formatDuration(event.EndedAt.Sub(event.StartedAt))
+	}
+	return notifier.send(target.RoomID, event.ID+"-offline", html)
+}
+
+func (notifier *MatrixNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	var lines []string
+	if event.GameChanged {
+		lines = append(lines, fmt.Sprintf("🎮 %s changed category to %s", htmlChannelLink(event.streamer()), htmlItalic(event.GameName)))
+	}
+	if event.TitleChanged {
+		lines = append(lines, fmt.Sprintf("📺 %s has a new title: %s", htmlChannelLink(event.streamer()), escapeHTML(event.Title)))
+	}
+	return notifier.send(target.RoomID, event.ID+"-update", strings.Join(lines, "<br>"))
+}
+
+// send posts an m.room.message with an HTML body and its plain-text fallback.
+// The transaction ID is derived from the event, so the homeserver ignores a
+// retry of a message it already accepted.
+func (notifier *MatrixNotifier) send(roomID, txnID, html string) error {
+	message := MatrixMessage{
+		MsgType:       "m.text",
+		Body:          stripHTML(strings.ReplaceAll(html, "<br>", "\n")),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: html,
+	}
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), url.PathEscape(txnID))
+	return notifier.request(http.MethodPut, path, message, nil)
+}
+
+// join joins a room (accepting a pending invite) given its ID or alias, and
+// returns its ID.
+func (notifier *MatrixNotifier) join(roomIDOrAlias string) (string, error) {
+	var result MatrixJoinResponse
+	if err := notifier.request(http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(roomIDOrAlias), struct{}{}, &result); err != nil {
+		return "", err
+	}
+	if result.RoomID == "" {
+		return "", fmt.Errorf("homeserver returned no room ID")
+	}
+	return result.RoomID, nil
+}
+
+// request calls the client-server API, see requestJSON.
+func (notifier *MatrixNotifier) request(method, path string, payload, result any) error {
+	if notifier.homeserverURL == "" {
+		return fmt.Errorf("%w: MATRIX_HOMESERVER_URL is not set", errOutboxStale)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+notifier.accessToken)
+	_, err := requestJSON(notifier.ctx, notifier.client, method, notifier.homeserverURL+path, header, payload, result)
+	return err
+}
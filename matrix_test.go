@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatrixNotifierLive(t *testing.T) {
+	var requests int
+	var message MatrixMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		if want := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/event1-live"; r.URL.EscapedPath() != want {
+			t.Errorf("path = %s, want %s", r.URL.EscapedPath(), want)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret-token" {
+			t.Errorf("Authorization = %q, want Bearer secret-token", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	defer server.Close()
+
+	notifier := &MatrixNotifier{
+		ctx:           context.Background(),
+		client:        server.Client(),
+		homeserverURL: server.URL,
+		accessToken:   "secret-token",
+	}
+	event := &NotificationEvent{
+		ID:          "event1",
+		Username:    "streamer",
+		DisplayName: "Streamer",
+		Title:       "Tips & <tricks>",
+		GameName:    "Chess",
+		ViewerCount: 7,
+	}
+	if _, err := notifier.Live(NotificationTarget{Type: notifierMatrix, RoomID: "!room:example.org"}, event); err != nil {
+		t.Fatalf("Live() error = %v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("got %d requests, want 1", requests)
+	}
+	if message.MsgType != "m.text" || message.Format != "org.matrix.custom.html" {
+		t.Errorf("msgtype = %q, format = %q", message.MsgType, message.Format)
+	}
+	if want := `🔴 <a href="https://twitch.tv/streamer"><b>Streamer</b></a> is now live!<br>📺 Tips &amp; &lt;tricks&gt;<br>🎮 <i>Chess</i><br>👥 7 viewers`; message.FormattedBody != want {
+		t.Errorf("formatted_body = %q, want %q", message.FormattedBody, want)
+	}
+	if want := "🔴 Streamer is now live!\n📺 Tips & <tricks>\n🎮 Chess\n👥 7 viewers"; message.Body != want {
+		t.Errorf("body = %q, want %q", message.Body, want)
+	}
+}
+
+func TestMatrixNotifierRetryableError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED"}`))
+	}))
+	defer server.Close()
+
+	notifier := &MatrixNotifier{ctx: context.Background(), client: server.Client(), homeserverURL: server.URL, accessToken: "secret-token"}
+	err := notifier.Offline(NotificationTarget{Type: notifierMatrix, RoomID: "!room:example.org"}, &NotificationEvent{ID: "event1", Username: "streamer", DisplayName: "Streamer"})
+	if !isRetryableNotificationError(err) {
+		t.Errorf("Offline() error = %v, want a retryable error", err)
+	}
+}
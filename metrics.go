@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		polls:             newCounterVec("tgtping_polls_total", "Polling cycles by result.", "result"),
+		twitchAPIRequests: newCounterVec("tgtping_twitch_api_requests_total", "Twitch API requests by HTTP status code (\"error\" when no response was received).", "status"),
+		tokenRefreshes:    newCounterVec("tgtping_twitch_token_refreshes_total", "Twitch app access token refreshes by result.", "result"),
+		notifications:     newCounterVec("tgtping_notifications_total", "Notification delivery attempts by kind and result.", "kind", "result"),
+		commands:          newCounterVec("tgtping_telegram_commands_total", "Telegram commands received by name.", "command"),
+	}
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]uint64),
+	}
+}
+
+// inc increments the counter for the given label values, in the order the
+// labels were declared.
+func (c *CounterVec) inc(labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[strings.Join(labelValues, "\x00")]++
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		pairs := make([]string, len(c.labels))
+		for i, value := range strings.Split(key, "\x00") {
+			if i < len(pairs) {
+				pairs[i] = fmt.Sprintf(`%s="%s"`, c.labels[i], labelValueEscaper.Replace(value))
+			}
+		}
+		fmt.Fprintf(b, "%s{%s} %d\n", c.name, strings.Join(pairs, ","), c.values[key])
+	}
+}
+
+func writeGauge(b *strings.Builder, name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (m *Metrics) recordPoll(err error) {
+	if err != nil {
+		m.polls.inc("error")
+		return
+	}
+	m.polls.inc("success")
+	m.lastSuccessfulPoll.Store(time.Now().Unix())
+}
+
+// handleMetrics serves the metrics in the Prometheus text exposition format.
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, counter := range []*CounterVec{
+		app.metrics.polls,
+		app.metrics.twitchAPIRequests,
+		app.metrics.tokenRefreshes,
+		app.metrics.notifications,
+		app.metrics.commands,
+	} {
+		counter.write(&b)
+	}
+
+	streamers := app.streamerManager.getStreamers()
+	live := 0
+	for _, streamer := range streamers {
+		if streamer.IsLive {
+			live++
+		}
+	}
+	writeGauge(&b, "tgtping_tracked_streamers", "Streamers tracked by at least one chat.", float64(len(streamers)))
+	writeGauge(&b, "tgtping_live_streamers", "Tracked streamers currently live.", float64(live))
+	circuitOpen := 0.0
+	if app.twitchBreaker.isOpen() {
+		circuitOpen = 1
+	}
+	writeGauge(&b, "tgtping_twitch_circuit_open", "1 while polling is paused because the Twitch API is unreachable.", circuitOpen)
+	writeGauge(&b, "tgtping_outbox_pending", "Notifications waiting in the outbox for delivery or a retry.", float64(app.outboxPending()))
+	writeGauge(&b, "tgtping_last_successful_poll_timestamp_seconds", "Unix time of the last polling cycle without errors.", float64(app.metrics.lastSuccessfulPoll.Load()))
+	writeGauge(&b, "tgtping_last_telegram_updates_timestamp_seconds", "Unix time of the last successful Telegram getUpdates call.", float64(app.metrics.lastTelegramUpdate.Load()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Notifier types, as used in NotificationTarget.Type
+const (
+	notifierTelegram = "telegram"
+	notifierDiscord  = "discord"
+	notifierWebhook  = "webhook"
+	notifierSlack    = "slack"
+	notifierMatrix   = "matrix"
+	notifierNtfy     = "ntfy"
+	notifierGotify   = "gotify"
+	notifierEmail    = "email"
+)
+
+func (app *App) newNotifiers() map[string]Notifier {
+	return map[string]Notifier{
+		notifierTelegram: &TelegramNotifier{app: app},
+		notifierDiscord:  &DiscordNotifier{ctx: app.ctx, client: app.httpClient},
+		notifierWebhook: &WebhookNotifier{
+			ctx:          app.ctx,
+			client:       &http.Client{Timeout: app.config.NotifyWebhookTimeout},
+			publicClient: newPublicHTTPClient(app.config.NotifyWebhookTimeout),
+			trustedURLs:  app.config.NotifyWebhookURLs,
+			secret:       app.config.NotifyWebhookSecret,
+			logPath:      WebhookDeliveryLogPath,
+		},
+		notifierSlack: &SlackNotifier{ctx: app.ctx, client: app.httpClient},
+		notifierMatrix: &MatrixNotifier{
+			ctx:           app.ctx,
+			client:        app.httpClient,
+			homeserverURL: app.config.MatrixHomeserverURL,
+			accessToken:   app.config.MatrixAccessToken,
+		},
+		notifierNtfy: &NtfyNotifier{
+			ctx:       app.ctx,
+			client:    app.httpClient,
+			serverURL: app.config.NtfyServerURL,
+			topic:     app.config.NtfyTopic,
+			token:     app.config.NtfyToken,
+			priority:  app.config.NtfyPriority,
+			tags:      app.config.NtfyTags,
+		},
+		notifierGotify: &GotifyNotifier{
+			ctx:       app.ctx,
+			client:    app.httpClient,
+			serverURL: app.config.GotifyURL,
+			token:     app.config.GotifyToken,
+			priority:  app.config.GotifyPriority,
+		},
+		notifierEmail: &EmailNotifier{
+			ctx:      app.ctx,
+			host:     app.config.SMTPHost,
+			port:     app.config.SMTPPort,
+			username: app.config.SMTPUsername,
+			password: app.config.SMTPPassword,
+			from:     app.config.SMTPFrom,
+			to:       app.config.SMTPTo,
+			security: app.config.SMTPSecurity,
+		},
+	}
+}
+
+// newNotificationEvent describes the current state of a streamer's session.
+func newNotificationEvent(streamer *Streamer, session *StreamSession) *NotificationEvent {
+	event := &NotificationEvent{
+		Username:    streamer.Username,
+		UserID:      streamer.UserID,
+		DisplayName: streamer.DisplayName,
+	}
+	if session != nil {
+		event.StreamID = session.StreamID
+		event.Title = session.Title
+		event.GameName = session.GameName
+		event.ViewerCount = session.ViewerCount
+		event.StartedAt = session.StartedAt
+	}
+	return event
+}
+
+// streamer returns the minimal streamer the HTML helpers need.
+func (event *NotificationEvent) streamer() *Streamer {
+	return &Streamer{Username: event.Username, UserID: event.UserID, DisplayName: event.DisplayName}
+}
+
+func (event *NotificationEvent) channelURL() string {
+	return "https://twitch.tv/" + event.Username
+}
+
+// notificationTargets returns where a chat's notifications for a streamer
+// go: the targets configured with /targets, or the chat itself.
+func (app *App) notificationTargets(chatID int64, username string) []NotificationTarget {
+	chat := app.streamerManager.getChatSettings(chatID)
+	if prefs, exists := chat.Streamers[username]; exists && len(prefs.Targets) > 0 {
+		targets := slices.Clone(prefs.Targets)
+		for i := range targets {
+			if targets[i].Type == notifierTelegram {
+				targets[i].ChatID = chatID
+			}
+		}
+		return targets
+	}
+	return []NotificationTarget{{Type: notifierTelegram, ChatID: chatID}}
+}
+
+// key identifies a target, so chats sharing a webhook notify it once and
+// entries for the same target are delivered in order.
+func (target NotificationTarget) key() string {
+	switch target.Type {
+	case notifierTelegram:
+		return fmt.Sprintf("%s:%d", target.Type, target.ChatID)
+	case notifierMatrix:
+		return target.Type + ":" + target.RoomID
+	default:
+		return target.Type + ":" + target.URL
+	}
+}
+
+// describe names a target without revealing webhook tokens.
+func (target NotificationTarget) describe() string {
+	switch target.Type {
+	case notifierTelegram:
+		return "Telegram (this chat)"
+	case notifierDiscord:
+		return "Discord webhook " + webhookID(target.URL)
+	case notifierWebhook:
+		return "Webhook " + redactURL(target.URL)
+	case notifierSlack:
+		return "Slack webhook " + slackWebhookID(target.URL)
+	case notifierMatrix:
+		return "Matrix room " + target.RoomID
+	case notifierNtfy:
+		return "ntfy topic " + redactURL(target.URL)
+	case notifierGotify:
+		return "Gotify " + redactURL(target.URL)
+	case notifierEmail:
+		return "Email " + strings.TrimPrefix(target.URL, "mailto:")
+	default:
+		return target.Type
+	}
+}
+
+// webhookID returns the ID part of a Discord webhook URL
+// (https://discord.com/api/webhooks/<id>/<token>).
+func webhookID(webhookURL string) string {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/api/webhooks/"), "/")
+	return parts[0]
+}
+
+func validateDiscordWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	switch parsed.Host {
+	case "discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com":
+	default:
+		return fmt.Errorf("not a Discord URL")
+	}
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/api/webhooks/"), "/")
+	if parsed.Scheme != "https" || !strings.HasPrefix(parsed.Path, "/api/webhooks/") || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected https://discord.com/api/webhooks/<id>/<token>")
+	}
+	return nil
+}
+
+// handleTargetsCommand shows or changes where a chat's notifications for a
+// streamer are sent: /targets <username> [add telegram|add discord <url>|add webhook <url>|add slack <url>|add matrix <room>|remove <n>|reset]
+func (app *App) handleTargetsCommand(chatID int64, args string) string {
+	username, rest := splitFirstArg(args)
+	username = strings.ToLower(username)
+	if username == "" {
+		return escapeHTML(targetsUsage)
+	}
+	if !app.streamerManager.isSubscribed(username, chatID) {
+		return fmt.Sprintf("❌ %s is not in the notification list", escapeHTML(username))
+	}
+
+	targets := app.notificationTargets(chatID, username)
+	action, rest := splitFirstArg(rest)
+	switch action {
+	case "":
+		return formatTargets(username, targets)
+	case "add":
+		target, err := app.parseTarget(strings.TrimSpace(rest))
+		if err != nil {
+			return fmt.Sprintf("❌ %s", escapeHTML(err.Error()))
+		}
+		if target.Type == notifierTelegram {
+			target.ChatID = chatID
+		}
+		if slices.Contains(targets, target) {
+			return fmt.Sprintf("⚠️ %s already receives notifications for %s", escapeHTML(target.describe()), escapeHTML(username))
+		}
+		targets = append(targets, target)
+	case "remove":
+		index, err := strconv.Atoi(strings.TrimSpace(rest))
+		if err != nil || index < 1 || index > len(targets) {
+			return fmt.Sprintf("❌ Give the number of the target to remove (1-%d)", len(targets))
+		}
+		if len(targets) == 1 {
+			return fmt.Sprintf("❌ This is the only target, use /remove %s to stop notifications", escapeHTML(username))
+		}
+		targets = slices.Delete(targets, index-1, index)
+	case "reset":
+		targets = nil
+	default:
+		return escapeHTML(targetsUsage)
+	}
+
+	// Only the chat itself is the default, no need to store it
+	if len(targets) == 1 && targets[0].Type == notifierTelegram {
+		targets = nil
+	}
+	if err := app.streamerManager.updateChatSettings(chatID, func(chat *ChatSettings) {
+		prefs := chat.streamerPrefs(username)
+		prefs.Targets = nil
+		for _, target := range targets {
+			// The chat ID is filled in when resolving targets
+			target.ChatID = 0
+			prefs.Targets = append(prefs.Targets, target)
+		}
+		chat.pruneStreamerPrefs(username)
+	}); err != nil {
+		return fmt.Sprintf("❌ Error saving targets: %s", escapeHTML(err.Error()))
+	}
+
+	return "✅ " + formatTargets(username, app.notificationTargets(chatID, username))
+}
+
+// parseTarget parses the target given to /targets add, checking that Discord
+// webhooks exist and joining Matrix rooms. Generic and Slack webhooks can't be
+// checked without posting to them; generic ones must at least be https URLs
+// of public hosts.
+func (app *App) parseTarget(args string) (NotificationTarget, error) {
+	targetType, rest := splitFirstArg(args)
+	switch strings.ToLower(targetType) {
+	case notifierTelegram:
+		return NotificationTarget{Type: notifierTelegram}, nil
+	case notifierDiscord:
+		webhookURL := strings.TrimSpace(rest)
+		if err := validateDiscordWebhookURL(webhookURL); err != nil {
+			return NotificationTarget{}, fmt.Errorf("invalid Discord webhook URL: %v", err)
+		}
+		if err := app.checkDiscordWebhook(webhookURL); err != nil {
+			return NotificationTarget{}, fmt.Errorf("unusable Discord webhook: %v", err)
+		}
+		return NotificationTarget{Type: notifierDiscord, URL: webhookURL}, nil
+	case notifierWebhook:
+		if app.config.NotifyWebhookSecret == "" {
+			return NotificationTarget{}, fmt.Errorf("webhook targets need NOTIFY_WEBHOOK_SECRET to be set")
+		}
+		webhookURL := strings.TrimSpace(rest)
+		if err := validatePublicWebhookURL(app.ctx, webhookURL); err != nil {
+			return NotificationTarget{}, fmt.Errorf("invalid webhook URL: %v", err)
+		}
+		return NotificationTarget{Type: notifierWebhook, URL: webhookURL}, nil
+	case notifierSlack:
+		webhookURL := strings.TrimSpace(rest)
+		if err := validateSlackWebhookURL(webhookURL, app.config.SlackWebhookHosts); err != nil {
+			return NotificationTarget{}, fmt.Errorf("invalid Slack webhook URL: %v", err)
+		}
+		return NotificationTarget{Type: notifierSlack, URL: webhookURL}, nil
+	case notifierMatrix:
+		if app.config.MatrixHomeserverURL == "" {
+			return NotificationTarget{}, fmt.Errorf("Matrix targets need MATRIX_HOMESERVER_URL and MATRIX_ACCESS_TOKEN to be set")
+		}
+		room := strings.TrimSpace(rest)
+		if !strings.HasPrefix(room, "!") && !strings.HasPrefix(room, "#") {
+			return NotificationTarget{}, fmt.Errorf("expected a Matrix room ID (!id:server) or alias (#alias:server)")
+		}
+		roomID, err := app.notifiers[notifierMatrix].(*MatrixNotifier).join(room)
+		if err != nil {
+			return NotificationTarget{}, fmt.Errorf("can't join Matrix room %s: %v", room, err)
+		}
+		return NotificationTarget{Type: notifierMatrix, RoomID: roomID}, nil
+	default:
+		return NotificationTarget{}, fmt.Errorf("%s", targetsUsage)
+	}
+}
+
+func formatTargets(username string, targets []NotificationTarget) string {
+	text := fmt.Sprintf("📣 Notifications for %s go to:\n", htmlBold(username))
+	for i, target := range targets {
+		text += fmt.Sprintf("%d. %s\n", i+1, escapeHTML(target.describe()))
+	}
+	return strings.TrimSuffix(text, "\n")
+}
+
+const targetsUsage = `usage: /targets <username> [add telegram | add discord <webhook_url> | add webhook <url> | add slack <webhook_url> | add matrix <room> | remove <n> | reset]`
+
+// requestJSON sends payload as JSON (json.RawMessage as is) and, if result
+// isn't nil, decodes the answer into it. It returns the status code of the
+// answer. Statuses other than 2xx are returned as *APIStatusError with the
+// start of the body, so the outbox retries rate limits and server errors
+// only; network errors are returned as is and retried too.
+func requestJSON(ctx context.Context, client *http.Client, method, requestURL string, header http.Header, payload, result any) (int, error) {
+	body, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, &APIStatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if result != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(result)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, nil
+}
+
+// postJSON posts payload as JSON, see requestJSON.
+func postJSON(ctx context.Context, client *http.Client, requestURL string, header http.Header, payload any) error {
+	_, err := requestJSON(ctx, client, http.MethodPost, requestURL, header, payload, nil)
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhook event types
+const (
+	webhookStreamOnline  = "stream.online"
+	webhookStreamOffline = "stream.offline"
+	webhookStreamUpdated = "stream.updated"
+)
+
+// errNonPublicAddress is returned for chat webhooks pointing at loopback,
+// private or otherwise internal addresses.
+var errNonPublicAddress = errors.New("webhook address is not public")
+
+// carrierGradeNAT is the shared address space of RFC 6598, internal despite
+// not being private.
+var carrierGradeNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+func (notifier *WebhookNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	return nil, notifier.post(target.URL, webhookStreamOnline, newWebhookPayload(webhookStreamOnline, event))
+}
+
+func (notifier *WebhookNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	payload := newWebhookPayload(webhookStreamOffline, event)
+	payload.EndedAt = &event.EndedAt
+	return notifier.post(target.URL, webhookStreamOffline, payload)
+}
+
+func (notifier *WebhookNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	payload := newWebhookPayload(webhookStreamUpdated, event)
+	payload.Changes = &WebhookChanges{Title: event.TitleChanged, Game: event.GameChanged}
+	return notifier.post(target.URL, webhookStreamUpdated, payload)
+}
+
+func newWebhookPayload(eventType string, event *NotificationEvent) *WebhookPayload {
+	payload := &WebhookPayload{
+		Version: WebhookPayloadVersion,
+		ID:      event.ID,
+		Type:    eventType,
+		Time:    event.Time,
+		Stream: TwitchStreamData{
+			ID:           event.StreamID,
+			UserID:       event.UserID,
+			UserLogin:    event.Username,
+			UserName:     event.DisplayName,
+			GameName:     event.GameName,
+			Title:        event.Title,
+			ViewerCount:  event.ViewerCount,
+			ThumbnailURL: event.ThumbnailURL,
+		},
+	}
+	if !event.StartedAt.IsZero() {
+		payload.Stream.StartedAt = event.StartedAt.UTC().Format(time.RFC3339)
+	}
+	return payload
+}
+
+// post sends a signed event. The signature follows the EventSub scheme:
+// sha256=HMAC-SHA256(secret, message ID + timestamp + body), hex encoded.
+// Retries are left to the outbox; the message ID stays the same across them
+// so receivers can deduplicate.
+func (notifier *WebhookNotifier) post(webhookURL, eventType string, payload *WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	header := http.Header{}
+	header.Set("Tgtping-Message-Id", payload.ID)
+	header.Set("Tgtping-Message-Timestamp", timestamp)
+	header.Set("Tgtping-Message-Signature", signWebhookPayload(notifier.secret, payload.ID, timestamp, body))
+	header.Set("Tgtping-Event-Type", eventType)
+	header.Set("Tgtping-Event-Version", strconv.Itoa(payload.Version))
+
+	delivery := WebhookDelivery{
+		Time: time.Now(),
+		ID:   payload.ID,
+		Type: eventType,
+		URL:  redactURL(webhookURL),
+	}
+	// The signed body is sent as is
+	delivery.StatusCode, err = requestJSON(notifier.ctx, notifier.clientFor(webhookURL), http.MethodPost, webhookURL, header, json.RawMessage(body), nil)
+	delivery.DurationMS = time.Since(delivery.Time).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	notifier.logDelivery(&delivery)
+	return err
+}
+
+// clientFor returns the client for a webhook: URLs from NOTIFY_WEBHOOK_URLS
+// are trusted, the ones chats added may only reach public addresses.
+func (notifier *WebhookNotifier) clientFor(webhookURL string) *http.Client {
+	if slices.Contains(notifier.trustedURLs, webhookURL) {
+		return notifier.client
+	}
+	return notifier.publicClient
+}
+
+func signWebhookPayload(secret, messageID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// logDelivery appends an attempt to the delivery log, rotating it to
+// <path>.1 once it grows past WebhookDeliveryLogMaxSize.
+func (notifier *WebhookNotifier) logDelivery(delivery *WebhookDelivery) {
+	notifier.logMutex.Lock()
+	defer notifier.logMutex.Unlock()
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		log.Printf("Error marshaling webhook delivery: %v", err)
+		return
+	}
+
+	if info, err := os.Stat(notifier.logPath); err == nil && info.Size() >= WebhookDeliveryLogMaxSize {
+		if err := os.Rename(notifier.logPath, notifier.logPath+".1"); err != nil {
+			log.Printf("Error rotating webhook delivery log %s: %v", notifier.logPath, err)
+		}
+	}
+
+	file, err := os.OpenFile(notifier.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error opening webhook delivery log %s: %v", notifier.logPath, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing webhook delivery log %s: %v", notifier.logPath, err)
+	}
+}
+
+// newEventID returns a random ID for a notification event.
+func newEventID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func validateWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("expected an http:// or https:// URL")
+	}
+	return nil
+}
+
+// validatePublicWebhookURL checks a webhook URL added by a chat: it must use
+// https and its host must only resolve to public addresses, so chats can't
+// make the bot probe its own network.
+func validatePublicWebhookURL(ctx context.Context, webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return fmt.Errorf("expected an https:// URL")
+	}
+
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("can't resolve %s: %v", parsed.Hostname(), err)
+	}
+	for _, address := range addresses {
+		if !isPublicAddress(address) {
+			return fmt.Errorf("%w: %s resolves to %s", errNonPublicAddress, parsed.Hostname(), address)
+		}
+	}
+	return nil
+}
+
+// newPublicHTTPClient returns a client for chat webhooks. Addresses are
+// checked when connecting, after DNS resolution, so a host can't be rebound
+// to an internal address once validated; proxies and redirects are not
+// followed for the same reason.
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicAddress reports whether an address is globally routable: not
+// loopback, private, link-local, unspecified, multicast or carrier-grade NAT.
+func isPublicAddress(address netip.Addr) bool {
+	address = address.Unmap()
+	return address.IsGlobalUnicast() && !address.IsPrivate() && !carrierGradeNAT.Contains(address)
+}
+
+// redactURL keeps the scheme, host and path of a URL, dropping credentials
+// and query parameters that may carry tokens.
+func redactURL(webhookURL string) string {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return parsed.Scheme + "://" + parsed.Host + parsed.Path
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicAddress(netip.MustParseAddr(tt.address)); got != tt.want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePublicWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hook", false},
+		{"http://93.184.216.34/hook", true},
+		{"https://127.0.0.1:8080/hook", true},
+		{"https://[::1]/hook", true},
+		{"https://169.254.169.254/latest/meta-data", true},
+		{"https:///hook", true},
+	}
+
+	for _, tt := range tests {
+		err := validatePublicWebhookURL(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePublicWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookNotifierRefusesInternalAddresses(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{
+		ctx:          context.Background(),
+		client:       server.Client(),
+		publicClient: newPublicHTTPClient(time.Second),
+		trustedURLs:  []string{server.URL + "/trusted"},
+		secret:       "secret",
+		logPath:      t.TempDir() + "/deliveries.jsonl",
+	}
+	event := &NotificationEvent{ID: "event1", Username: "streamer", DisplayName: "Streamer"}
+
+	err := notifier.Offline(NotificationTarget{Type: notifierWebhook, URL: server.URL + "/chat"}, event)
+	if !errors.Is(err, errNonPublicAddress) {
+		t.Fatalf("Offline() to a chat webhook on loopback error = %v, want errNonPublicAddress", err)
+	}
+	if isRetryableNotificationError(err) {
+		t.Errorf("refused address treated as retryable: %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("server got %d requests, want 0", requests)
+	}
+
+	if err := notifier.Offline(NotificationTarget{Type: notifierWebhook, URL: server.URL + "/trusted"}, event); err != nil {
+		t.Fatalf("Offline() to a configured webhook error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("server got %d requests, want 1", requests)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var ntfyTopicPattern = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// Priorities of notifications other than live ones, ntfy's and Gotify's
+// defaults
+const (
+	ntfyDefaultPriority   = 3
+	gotifyDefaultPriority = 5
+)
+
+func (notifier *NtfyNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	title, message := pushText(outboxLive, event)
+	return nil, notifier.publish(NtfyMessage{
+		Title:    title,
+		Message:  message,
+		Tags:     append([]string{"red_circle"}, notifier.tags...),
+		Priority: notifier.priority,
+		Click:    event.channelURL(),
+		Attach:   event.ThumbnailURL,
+	})
+}
+
+func (notifier *NtfyNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	title, message := pushText(outboxOffline, event)
+	return notifier.publish(NtfyMessage{
+		Title:    title,
+		Message:  message,
+		Tags:     append([]string{"black_circle"}, notifier.tags...),
+		Priority: ntfyDefaultPriority,
+		Click:    event.channelURL(),
+	})
+}
+
+func (notifier *NtfyNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	title, message := pushText(outboxUpdate, event)
+	return notifier.publish(NtfyMessage{
+		Title:    title,
+		Message:  message,
+		Tags:     append([]string{"pencil2"}, notifier.tags...),
+		Priority: ntfyDefaultPriority,
+		Click:    event.channelURL(),
+	})
+}
+
+// publish sends a message as JSON to the server root, which unlike the
+// header-based API handles non-ASCII titles. See requestJSON for errors.
+func (notifier *NtfyNotifier) publish(message NtfyMessage) error {
+	message.Topic = notifier.topic
+	header := http.Header{}
+	if notifier.token != "" {
+		header.Set("Authorization", "Bearer "+notifier.token)
+	}
+	return postJSON(notifier.ctx, notifier.client, notifier.serverURL, header, message)
+}
+
+// pushText returns the title and plain-text body of a push notification.
+func pushText(kind string, event *NotificationEvent) (string, string) {
+	var title string
+	var lines []string
+	switch kind {
+	case outboxLive:
+		title = event.DisplayName + " is now live!"
+		lines = append(lines, event.Title)
+		if event.GameName != "" {
+			lines = append(lines, "🎮 "+event.GameName)
+		}
+		lines = append(lines, fmt.Sprintf("👥 %d viewers", event.ViewerCount))
+	case outboxOffline:
+		title = event.DisplayName + " went offline"
+		lines = append(lines, event.Title)
+		if !event.StartedAt.IsZero() {
+			lines = append(lines, "⏱️ Streamed for "+formatDuration(event.EndedAt.Sub(event.StartedAt)))
+		}
+	case outboxUpdate:
+		title = event.DisplayName + " updated the stream"
+		if event.GameChanged {
+			lines = append(lines, "🎮 "+event.GameName)
+		}
+		if event.TitleChanged {
+			lines = append(lines, "📺 "+event.Title)
+		}
+	}
+	return title, strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of outbox entries
+const (
+	outboxLive    = "live"
+	outboxOffline = "offline"
+	outboxUpdate  = "update"
+)
+
+// outboxIdleInterval is how often the outbox is checked when nothing woke
+// the sender, as a safety net.
+const outboxIdleInterval = time.Minute
+
+// errOutboxStale marks an entry that is no longer worth delivering.
+var errOutboxStale = errors.New("notification is stale")
+
+// outboxEntries fans an event out to the targets of every subscribed chat,
+// skipping chats that turned the toggle off (if any) and targets shared by
+// several chats after the first, then to the targets configured globally.
+func (app *App) outboxEntries(kind string, event *NotificationEvent, toggle *NotificationToggle) []*OutboxEntry {
+	event.ID = newEventID()
+	event.Time = time.Now()
+
+	var entries []*OutboxEntry
+	seen := make(map[string]bool)
+	for _, chatID := range app.streamerManager.getSubscribers(event.Username) {
+		if toggle != nil && !app.toggleEnabled(toggle, chatID, event.Username) {
+			continue
+		}
+		for _, target := range app.notificationTargets(chatID, event.Username) {
+			if seen[target.key()] {
+				continue
+			}
+			seen[target.key()] = true
+
+			entries = append(entries, &OutboxEntry{
+				Kind:      kind,
+				ChatID:    chatID,
+				Username:  event.Username,
+				UserID:    event.UserID,
+				Target:    target,
+				Event:     *event,
+				CreatedAt: time.Now(),
+			})
+		}
+	}
+
+	for _, target := range app.globalTargets() {
+		// Push notifications follow the default toggles, webhooks get every
+		// event of every streamer
+		if seen[target.key()] || (toggle != nil && target.Type != notifierWebhook && !toggle.Default(&app.config)) {
+			continue
+		}
+		seen[target.key()] = true
+
+		entries = append(entries, &OutboxEntry{
+			Kind:      kind,
+			Username:  event.Username,
+			UserID:    event.UserID,
+			Target:    target,
+			Event:     *event,
+			CreatedAt: time.Now(),
+		})
+	}
+	return entries
+}
+
+// globalTargets returns the targets receiving every streamer's events: the
+// webhooks from NOTIFY_WEBHOOK_URLS, the ntfy topic and Gotify server, and
+// the email recipients unless they get a daily digest instead, if configured.
+func (app *App) globalTargets() []NotificationTarget {
+	var targets []NotificationTarget
+	for _, webhookURL := range app.config.NotifyWebhookURLs {
+		targets = append(targets, NotificationTarget{Type: notifierWebhook, URL: webhookURL})
+	}
+	if app.config.NtfyTopic != "" {
+		targets = append(targets, NotificationTarget{Type: notifierNtfy, URL: app.config.NtfyServerURL + "/" + app.config.NtfyTopic})
+	}
+	if app.config.GotifyURL != "" {
+		targets = append(targets, NotificationTarget{Type: notifierGotify, URL: app.config.GotifyURL})
+	}
+	if app.config.SMTPHost != "" && app.config.EmailMode == EmailModeImmediate {
+		targets = append(targets, NotificationTarget{Type: notifierEmail, URL: "mailto:" + strings.Join(app.config.SMTPTo, ",")})
+	}
+	return targets
+}
+
+// startOutbox starts the sender, which first replays the notifications left
+// pending by a previous run.
+func (app *App) startOutbox() {
+	entries, err := app.streamerManager.listOutbox()
+	if err != nil {
+		log.Printf("Error loading notification outbox: %v", err)
+	} else if len(entries) > 0 {
+		log.Printf("Replaying %d pending notifications from the outbox", len(entries))
+	}
+
+	go app.runOutbox()
+}
+
+// wakeOutbox tells the sender that entries were enqueued.
+func (app *App) wakeOutbox() {
+	select {
+	case app.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+func (app *App) runOutbox() {
+	for {
+		wait := outboxIdleInterval
+		if next := app.drainOutbox(); !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-app.ctx.Done():
+			timer.Stop()
+			return
+		case <-app.outboxWake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// drainOutbox delivers every entry that is due and returns when the earliest
+// postponed one should be retried (zero if none). Targets are served
+// concurrently, but for each target entries are sent in order and a failed
+// entry holds back the ones after it.
+func (app *App) drainOutbox() time.Time {
+	entries, err := app.streamerManager.listOutbox()
+	if err != nil {
+		log.Printf("Error loading notification outbox: %v", err)
+		return time.Now().Add(OutboxRetryBaseDelay)
+	}
+
+	var targets []string
+	byTarget := make(map[string][]OutboxEntry)
+	for _, entry := range entries {
+		key := entry.Target.key()
+		if _, exists := byTarget[key]; !exists {
+			targets = append(targets, key)
+		}
+		byTarget[key] = append(byTarget[key], entry)
+	}
+
+	var next time.Time
+	var nextMutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, key := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, entry := range byTarget[key] {
+				if retryAt := app.processOutboxEntry(&entry); !retryAt.IsZero() {
+					nextMutex.Lock()
+					if next.IsZero() || retryAt.Before(next) {
+						next = retryAt
+					}
+					nextMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return next
+}
+
+// processOutboxEntry tries to deliver an entry that is due and removes it
+// once it is delivered, stale or out of attempts. It returns when the entry
+// should be retried, or zero if it is done.
+func (app *App) processOutboxEntry(entry *OutboxEntry) time.Time {
+	if time.Now().Before(entry.NextAttemptAt) {
+		return entry.NextAttemptAt
+	}
+
+	err := app.deliverOutboxEntry(entry)
+	switch {
+	case err == nil:
+		app.metrics.notifications.inc(entry.Kind, "sent")
+	case errors.Is(err, errOutboxStale):
+		log.Printf("Dropping %s notification for %s to %s: %v", entry.Kind, entry.Username, entry.Target.describe(), err)
+		app.metrics.notifications.inc(entry.Kind, "dropped")
+	case app.ctx.Err() != nil:
+		// Shutting down: leave the entry untouched for the next run
+		return time.Time{}
+	default:
+		entry.Attempts++
+		entry.LastError = err.Error()
+		app.metrics.notifications.inc(entry.Kind, "failed")
+
+		if isRetryableNotificationError(err) && entry.Attempts < OutboxMaxAttempts {
+			entry.NextAttemptAt = time.Now().Add(retryDelay(entry.Attempts, OutboxRetryBaseDelay, OutboxRetryMaxDelay))
+			log.Printf("Error sending %s notification for %s to %s (attempt %d/%d), retrying at %s: %v",
+				entry.Kind, entry.Username, entry.Target.describe(), entry.Attempts, OutboxMaxAttempts, entry.NextAttemptAt.Format(time.RFC3339), err)
+			if app.streamerManager.updateOutboxEntry(entry) != nil {
+				return time.Now().Add(OutboxRetryBaseDelay)
+			}
+			return entry.NextAttemptAt
+		}
+		log.Printf("Giving up on %s notification for %s to %s after %d attempts: %v", entry.Kind, entry.Username, entry.Target.describe(), entry.Attempts, err)
+		app.metrics.notifications.inc(entry.Kind, "dropped")
+		app.deadLetter(entry, err)
+	}
+
+	if app.streamerManager.removeOutboxEntry(entry) != nil {
+		// Retry the removal rather than moving on and risking the entries
+		// after it being sent first
+		return time.Now().Add(OutboxRetryBaseDelay)
+	}
+	return time.Time{}
+}
+
+// deadLetter appends an entry the outbox gave up on to the dead-letter log,
+// so it can be inspected or resent by hand.
+func (app *App) deadLetter(entry *OutboxEntry, err error) {
+	data, marshalErr := json.Marshal(DeadLetter{
+		Time:     time.Now(),
+		Kind:     entry.Kind,
+		ChatID:   entry.ChatID,
+		Username: entry.Username,
+		Target:   entry.Target,
+		Event:    entry.Event,
+		Error:    err.Error(),
+		Attempts: entry.Attempts,
+	})
+	if marshalErr != nil {
+		log.Printf("Error marshaling dead letter: %v", marshalErr)
+		return
+	}
+
+	file, openErr := os.OpenFile(app.deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if openErr != nil {
+		log.Printf("Error opening dead-letter log %s: %v", app.deadLetterPath, openErr)
+		return
+	}
+	defer file.Close()
+
+	if _, writeErr := file.Write(append(data, '\n')); writeErr != nil {
+		log.Printf("Error writing dead-letter log %s: %v", app.deadLetterPath, writeErr)
+	}
+}
+
+// deliverOutboxEntry hands an entry to the notifier of its target, unless
+// the chat unsubscribed or removed the target in the meantime. Entries
+// without a chat are for global targets, which stay current as long as they
+// are configured.
+func (app *App) deliverOutboxEntry(entry *OutboxEntry) error {
+	if entry.ChatID == 0 {
+		if !slices.Contains(app.globalTargets(), entry.Target) {
+			return errOutboxStale
+		}
+	} else if !app.streamerManager.isSubscribed(entry.Username, entry.ChatID) ||
+		!slices.Contains(app.notificationTargets(entry.ChatID, entry.Username), entry.Target) {
+		return errOutboxStale
+	}
+	notifier, exists := app.notifiers[entry.Target.Type]
+	if !exists {
+		return fmt.Errorf("%w: unknown notifier %q", errOutboxStale, entry.Target.Type)
+	}
+
+	switch entry.Kind {
+	case outboxLive:
+		return app.deliverLiveNotification(notifier, entry)
+	case outboxOffline:
+		return notifier.Offline(entry.Target, &entry.Event)
+	case outboxUpdate:
+		return notifier.Update(entry.Target, &entry.Event)
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errOutboxStale, entry.Kind)
+	}
+}
+
+// deliverLiveNotification sends a live notification and attaches the message
+// to the session, if the notifier can edit it, so it gets updated as the
+// stream goes on. Notifications for a stream that already ended are dropped.
+func (app *App) deliverLiveNotification(notifier Notifier, entry *OutboxEntry) error {
+	if !app.isCurrentSession(entry) {
+		return errOutboxStale
+	}
+
+	message, err := notifier.Live(entry.Target, &entry.Event)
+	if err != nil || message == nil {
+		return err
+	}
+
+	app.statusMutex.Lock()
+	defer app.statusMutex.Unlock()
+
+	session := app.streamerManager.getSession(entry.UserID)
+	if session == nil || !session.StartedAt.Equal(entry.SessionStart) {
+		log.Printf("Stream of %s ended while notifying %s, live message won't be updated", entry.Username, entry.Target.describe())
+		return nil
+	}
+	session.Messages = append(session.Messages, *message)
+	// The message is out: don't let a failed save trigger a resend
+	app.streamerManager.updateStreamerStatus(entry.UserID, true, session)
+	return nil
+}
+
+func (app *App) isCurrentSession(entry *OutboxEntry) bool {
+	app.statusMutex.Lock()
+	defer app.statusMutex.Unlock()
+
+	session := app.streamerManager.getSession(entry.UserID)
+	return session != nil && session.StartedAt.Equal(entry.SessionStart)
+}
+
+// saveStatus saves a status change with the notifications it triggered and
+// wakes the sender to deliver them.
+func (app *App) saveStatus(userID string, isLive bool, session *StreamSession, outbox []*OutboxEntry) error {
+	if err := app.streamerManager.updateStreamerStatus(userID, isLive, session, outbox...); err != nil {
+		return err
+	}
+	if len(outbox) > 0 {
+		app.wakeOutbox()
+	}
+	return nil
+}
+
+// isRetryableNotificationError reports whether a failed delivery is worth
+// retrying: Telegram flood control and server errors, webhook rate limits
+// and server errors, temporary SMTP failures, and network errors other than
+// a chat webhook resolving to an internal address.
+func isRetryableNotificationError(err error) bool {
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code < 500
+	}
+	if errors.Is(err, errNonPublicAddress) {
+		return false
+	}
+	_, retryable := telegramRetryDelay(err, 1)
+	return retryable
+}
+
+// outboxPending counts the undelivered notifications for the metrics.
+func (app *App) outboxPending() int {
+	entries, err := app.streamerManager.listOutbox()
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookURL = "https://example.com/hook"
+
+// fakeNotifier records the events it is given and fails with err, if set.
+type fakeNotifier struct {
+	mutex  sync.Mutex
+	events []string
+	err    error
+}
+
+func (notifier *fakeNotifier) record(kind string, event *NotificationEvent) error {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	notifier.events = append(notifier.events, kind+" "+event.ID)
+	return notifier.err
+}
+
+func (notifier *fakeNotifier) sent() []string {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	return append([]string(nil), notifier.events...)
+}
+
+func (notifier *fakeNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	return nil, notifier.record(outboxLive, event)
+}
+
+func (notifier *fakeNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	return notifier.record(outboxOffline, event)
+}
+
+func (notifier *fakeNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	return notifier.record(outboxUpdate, event)
+}
+
+// newOutboxTestApp returns an app whose only target is a global webhook
+// handled by notifier, with an offline streamer to notify about.
+func newOutboxTestApp(t *testing.T, filename string, notifier Notifier) *App {
+	t.Helper()
+	app := newTestApp(t)
+
+	store, err := NewJSONStore(filename, 0)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	if app.streamerManager, err = NewStreamerManager(store); err != nil {
+		t.Fatalf("NewStreamerManager() error = %v", err)
+	}
+	if app.findStreamerByUserID("42") == nil {
+		if err := app.streamerManager.addStreamer(&Streamer{Username: "streamer", DisplayName: "Streamer", UserID: "42"}); err != nil {
+			t.Fatalf("addStreamer() error = %v", err)
+		}
+	}
+
+	app.config.NotifyWebhookURLs = []string{testWebhookURL}
+	app.notifiers = map[string]Notifier{notifierWebhook: notifier}
+	return app
+}
+
+func newTestOutboxEntry(kind, eventID string) *OutboxEntry {
+	return &OutboxEntry{
+		Kind:      kind,
+		Username:  "streamer",
+		UserID:    "42",
+		Target:    NotificationTarget{Type: notifierWebhook, URL: testWebhookURL},
+		Event:     NotificationEvent{ID: eventID, Username: "streamer", DisplayName: "Streamer"},
+		CreatedAt: time.Now(),
+	}
+}
+
+func outboxLength(t *testing.T, app *App) int {
+	t.Helper()
+	entries, err := app.streamerManager.listOutbox()
+	if err != nil {
+		t.Fatalf("listOutbox() error = %v", err)
+	}
+	return len(entries)
+}
+
+func readDeadLetters(t *testing.T, filename string) []DeadLetter {
+	t.Helper()
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("opening dead-letter log: %v", err)
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("invalid dead letter %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestOutboxReplaysPendingEntriesAtStartup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+
+	// A previous run saved notifications but stopped before sending them
+	previous := newOutboxTestApp(t, filename, &fakeNotifier{})
+	pending := []*OutboxEntry{newTestOutboxEntry(outboxOffline, "event1"), newTestOutboxEntry(outboxUpdate, "event2")}
+	if err := previous.streamerManager.updateStreamerStatus("42", false, nil, pending...); err != nil {
+		t.Fatalf("updateStreamerStatus() error = %v", err)
+	}
+
+	notifier := &fakeNotifier{}
+	app := newOutboxTestApp(t, filename, notifier)
+	app.startOutbox()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for outboxLength(t, app) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending notifications weren't replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sent := notifier.sent()
+	if len(sent) != 2 || sent[0] != "offline event1" || sent[1] != "update event2" {
+		t.Errorf("sent %v, want the pending notifications in order", sent)
+	}
+}
+
+func TestOutboxDropsLiveEntryOfEndedSession(t *testing.T) {
+	notifier := &fakeNotifier{}
+	app := newOutboxTestApp(t, filepath.Join(t.TempDir(), "streamers.json"), notifier)
+
+	// The stream went offline before its live notification was sent
+	entry := newTestOutboxEntry(outboxLive, "event1")
+	entry.SessionStart = time.Now().Add(-time.Hour)
+	if err := app.streamerManager.updateStreamerStatus("42", false, nil, entry); err != nil {
+		t.Fatalf("updateStreamerStatus() error = %v", err)
+	}
+
+	if next := app.drainOutbox(); !next.IsZero() {
+		t.Errorf("drainOutbox() scheduled a retry at %v", next)
+	}
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Errorf("sent %v for an ended stream", sent)
+	}
+	if n := outboxLength(t, app); n != 0 {
+		t.Errorf("outbox has %d entries, want the stale one dropped", n)
+	}
+	if letters := readDeadLetters(t, app.deadLetterPath); len(letters) != 0 {
+		t.Errorf("stale entry dead-lettered: %+v", letters)
+	}
+}
+
+func TestOutboxDeliveryErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		wantRetry       bool
+		wantDeadLetters int
+	}{
+		{"retryable error", &APIStatusError{StatusCode: 503, Body: "unavailable"}, true, 0},
+		{"non-retryable error", &APIStatusError{StatusCode: 404, Body: "not found"}, false, 1},
+		{"non-public address", errNonPublicAddress, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier := &fakeNotifier{err: tt.err}
+			app := newOutboxTestApp(t, filepath.Join(t.TempDir(), "streamers.json"), notifier)
+			if err := app.streamerManager.updateStreamerStatus("42", false, nil, newTestOutboxEntry(outboxOffline, "event1")); err != nil {
+				t.Fatalf("updateStreamerStatus() error = %v", err)
+			}
+
+			next := app.drainOutbox()
+			if len(notifier.sent()) != 1 {
+				t.Fatalf("sent %v, want a single attempt", notifier.sent())
+			}
+
+			entries, err := app.streamerManager.listOutbox()
+			if err != nil {
+				t.Fatalf("listOutbox() error = %v", err)
+			}
+			if tt.wantRetry {
+				if next.IsZero() || len(entries) != 1 || entries[0].Attempts != 1 || !entries[0].NextAttemptAt.Equal(next) {
+					t.Errorf("retry at %v with outbox %+v, want the entry kept for a retry", next, entries)
+				}
+			} else if !next.IsZero() || len(entries) != 0 {
+				t.Errorf("retry at %v with outbox %+v, want the entry removed", next, entries)
+			}
+
+			letters := readDeadLetters(t, app.deadLetterPath)
+			if len(letters) != tt.wantDeadLetters {
+				t.Fatalf("got %d dead letters, want %d", len(letters), tt.wantDeadLetters)
+			}
+			if len(letters) > 0 {
+				letter := letters[0]
+				if letter.Kind != outboxOffline || letter.Event.ID != "event1" || letter.Attempts != 1 || letter.Error != tt.err.Error() {
+					t.Errorf("dead letter = %+v", letter)
+				}
+			}
+		})
+	}
+}
@@ -40,12 +40,39 @@ func (app *App) stopPollingManager() {
 }
 
 func (app *App) pollStreamStatus() error {
+	// While the breaker is open only one poll per cooldown goes through, as
+	// a probe of whether Twitch is reachable again.
+	if !app.twitchBreaker.allow(time.Now()) {
+		return nil
+	}
+
+	err := app.pollStreamers(app.getPolledStreamers())
+	app.metrics.recordPoll(err)
+	return err
+}
+
+// getPolledStreamers returns the streamers not already covered by an active
+// EventSub subscription, which is every streamer while the socket is down.
+// Live streamers are always polled to keep their live messages up to date.
+func (app *App) getPolledStreamers() []*Streamer {
 	streamers := app.streamerManager.getStreamers()
+
+	var polled []*Streamer
+	for _, streamer := range streamers {
+		if streamer.IsLive || !app.isCoveredByEventSub(streamer.UserID) {
+			polled = append(polled, streamer)
+		}
+	}
+	return polled
+}
+
+func (app *App) pollStreamers(streamers []*Streamer) error {
 	if len(streamers) == 0 {
 		return nil
 	}
 
 	batchSize := 100
+	failedBatches := 0
 	for i := 0; i < len(streamers); i += batchSize {
 		end := i + batchSize
 		if end > len(streamers) {
@@ -55,13 +82,14 @@ func (app *App) pollStreamStatus() error {
 		batch := streamers[i:end]
 		if err := app.pollStreamerBatch(batch); err != nil {
 			log.Printf("Error polling batch %d-%d: %v", i, end-1, err)
+			failedBatches++
 		}
 
-		if end < len(streamers) {
-			time.Sleep(1 * time.Second)
-		}
 	}
 
+	if failedBatches > 0 {
+		return fmt.Errorf("%d of %d batches failed", failedBatches, (len(streamers)+batchSize-1)/batchSize)
+	}
 	return nil
 }
 
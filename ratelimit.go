@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimitWarnDelay is the wait above which throttling is logged.
+const rateLimitWarnDelay = 5 * time.Second
+
+func newRateLimiter(name string, capacity int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		name:       name,
+		window:     window,
+		capacity:   float64(capacity),
+		tokens:     float64(capacity),
+		lastRefill: time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent and takes a token for it.
+func (rl *RateLimiter) wait(ctx context.Context) error {
+	for {
+		delay := rl.reserve(time.Now())
+		if delay <= 0 {
+			return nil
+		}
+		if delay >= rateLimitWarnDelay {
+			log.Printf("%s rate limit reached, waiting %v", rl.name, delay.Round(time.Second))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before
+// trying again.
+func (rl *RateLimiter) reserve(now time.Time) time.Duration {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if now.Before(rl.blockedUntil) {
+		return rl.blockedUntil.Sub(now)
+	}
+	if !rl.blockedUntil.IsZero() {
+		// Twitch refills the whole bucket at the reset time
+		rl.blockedUntil = time.Time{}
+		rl.tokens = rl.capacity
+		rl.lastRefill = now
+	}
+	rl.refill(now)
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate() * float64(time.Second))
+}
+
+func (rl *RateLimiter) rate() float64 {
+	return rl.capacity / rl.window.Seconds()
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.lastRefill).Seconds()
+	if elapsed > 0 {
+		rl.tokens = min(rl.capacity, rl.tokens+elapsed*rl.rate())
+		rl.lastRefill = now
+	}
+}
+
+// observe resynchronizes the bucket with the Ratelimit-Limit,
+// Ratelimit-Remaining and Ratelimit-Reset headers of a Helix response, so
+// requests made with the same token elsewhere are accounted for.
+func (rl *RateLimiter) observe(header http.Header) {
+	limit, limitErr := strconv.Atoi(header.Get("Ratelimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	reset, resetOK := parseRateLimitReset(header)
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	rl.refill(now)
+	if limitErr == nil && limit > 0 {
+		rl.capacity = float64(limit)
+	}
+	if remainingErr == nil {
+		rl.tokens = min(rl.tokens, float64(remaining))
+		if remaining == 0 && resetOK && reset.After(rl.blockedUntil) {
+			rl.blockedUntil = reset
+		}
+	}
+}
+
+// throttle blocks the bucket after an HTTP 429 until the reset time given by
+// Twitch (or a second if it's missing) and returns how long that is.
+func (rl *RateLimiter) throttle(header http.Header) time.Duration {
+	now := time.Now()
+	reset, ok := parseRateLimitReset(header)
+	if !ok || !reset.After(now) {
+		reset = now.Add(time.Second)
+	}
+	return rl.blockUntil(reset, now)
+}
+
+// blockUntil empties the bucket and holds every request until reset, then
+// returns how long the bucket is blocked.
+func (rl *RateLimiter) blockUntil(reset, now time.Time) time.Duration {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.tokens = 0
+	rl.lastRefill = now
+	if reset.After(rl.blockedUntil) {
+		rl.blockedUntil = reset
+	}
+	return rl.blockedUntil.Sub(now)
+}
+
+func parseRateLimitReset(header http.Header) (time.Time, bool) {
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(reset, 0), true
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter("test", 10, 10*time.Second)
+	rl.lastRefill = now
+
+	for i := range 10 {
+		if delay := rl.reserve(now); delay != 0 {
+			t.Fatalf("request %d delayed %v with tokens left", i+1, delay)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  time.Duration
+	}{
+		{"bucket empty", 0, time.Second},
+		{"half a token refilled", 500 * time.Millisecond, 500 * time.Millisecond},
+		{"token refilled", time.Second, 0},
+		{"next token not refilled yet", time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		if delay := rl.reserve(now.Add(tt.after)); delay != tt.want {
+			t.Errorf("%s: reserve() = %v, want %v", tt.name, delay, tt.want)
+		}
+	}
+
+	// An idle bucket refills up to its capacity only
+	later := now.Add(time.Hour)
+	for i := range 10 {
+		if delay := rl.reserve(later); delay != 0 {
+			t.Fatalf("request %d after idling delayed %v", i+1, delay)
+		}
+	}
+	if delay := rl.reserve(later); delay <= 0 {
+		t.Error("bucket refilled above its capacity")
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	rateLimitHeader := func(limit, remaining int, reset time.Time) http.Header {
+		header := http.Header{}
+		header.Set("Ratelimit-Limit", strconv.Itoa(limit))
+		header.Set("Ratelimit-Remaining", strconv.Itoa(remaining))
+		header.Set("Ratelimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		return header
+	}
+
+	t.Run("remaining lowers the tokens", func(t *testing.T) {
+		rl := newRateLimiter("test", 800, time.Minute)
+		rl.observe(rateLimitHeader(800, 3, time.Now().Add(time.Minute)))
+
+		now := time.Now()
+		for i := range 3 {
+			if delay := rl.reserve(now); delay != 0 {
+				t.Fatalf("request %d delayed %v with tokens left", i+1, delay)
+			}
+		}
+		if delay := rl.reserve(now); delay <= 0 {
+			t.Error("request allowed beyond Ratelimit-Remaining")
+		}
+	})
+
+	t.Run("remaining never raises the tokens", func(t *testing.T) {
+		rl := newRateLimiter("test", 2, time.Hour)
+		now := time.Now()
+		rl.reserve(now)
+		rl.reserve(now)
+		rl.observe(rateLimitHeader(2, 2, time.Now().Add(time.Hour)))
+
+		if delay := rl.reserve(time.Now()); delay <= 0 {
+			t.Error("request allowed after Ratelimit-Remaining reported tokens the bucket doesn't have")
+		}
+	})
+
+	t.Run("exhausted bucket waits for the reset", func(t *testing.T) {
+		rl := newRateLimiter("test", 800, time.Minute)
+		reset := time.Now().Add(30 * time.Second).Truncate(time.Second)
+		rl.observe(rateLimitHeader(800, 0, reset))
+
+		now := time.Now()
+		if delay := rl.reserve(now); delay != reset.Sub(now) {
+			t.Errorf("reserve() = %v, want %v until the reset", delay, reset.Sub(now))
+		}
+
+		// The whole bucket is available again at the reset time
+		for i := range 800 {
+			if delay := rl.reserve(reset); delay != 0 {
+				t.Fatalf("request %d after the reset delayed %v", i+1, delay)
+			}
+		}
+	})
+
+	t.Run("limit updates the capacity", func(t *testing.T) {
+		rl := newRateLimiter("test", 800, time.Minute)
+		rl.observe(rateLimitHeader(30, 30, time.Now().Add(time.Minute)))
+		if rl.capacity != 30 {
+			t.Errorf("capacity = %v, want 30", rl.capacity)
+		}
+	})
+}
+
+func TestMakeTwitchAPIRequestRateLimitRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// Number of HTTP 429 answers before a 200
+		rateLimited  int
+		wantRequests int32
+		wantStatus   int
+	}{
+		{"retried after a 429", 1, 2, http.StatusOK},
+		{"gives up after the maximum retries", MaxRateLimitRetries, MaxRateLimitRetries, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer app-token" {
+					t.Errorf("Authorization = %q, want Bearer app-token", got)
+				}
+				if int(requests.Add(1)) <= tt.rateLimited {
+					// Without a usable reset time the bucket is blocked for a second
+					w.Header().Set("Ratelimit-Remaining", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{"data":[]}`))
+			}))
+			defer server.Close()
+
+			app := newTestApp(t)
+			app.httpClient = server.Client()
+			app.config.TwitchClientID = "client-id"
+			app.rateLimiter = newRateLimiter("Twitch", TwitchRateLimitDefault, TwitchRateLimitWindow)
+
+			start := time.Now()
+			resp, err := app.makeTwitchAPIRequestWithToken(app.ctx, http.MethodGet, server.URL+"/helix/streams", "app-token", nil)
+			if err != nil {
+				t.Fatalf("makeTwitchAPIRequestWithToken() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", got, tt.wantRequests)
+			}
+			if elapsed := time.Since(start); elapsed < time.Duration(tt.wantRequests-1)*time.Second {
+				t.Errorf("retries sent after %v, want them to wait for the bucket to reset", elapsed)
+			}
+		})
+	}
+}
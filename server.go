@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// reservedHTTPPaths are the routes of the built-in HTTP server, which the
+// EventSub webhook callback can't use.
+var reservedHTTPPaths = []string{"/metrics", "/healthz", "/readyz"}
+
+func (app *App) startHTTPServer() {
+	app.httpMux.HandleFunc("/metrics", app.handleMetrics)
+	app.httpMux.HandleFunc("/healthz", app.handleHealthz)
+	app.httpMux.HandleFunc("/readyz", app.handleReadyz)
+
+	app.httpServer = &http.Server{
+		Addr:              app.config.HTTPListenAddr,
+		Handler:           app.httpMux,
+		ReadHeaderTimeout: DefaultHTTPTimeout,
+	}
+
+	go func() {
+		log.Printf("Starting HTTP server on %s", app.config.HTTPListenAddr)
+		if err := app.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+}
+
+func (app *App) stopHTTPServer() {
+	if app.httpServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultHTTPTimeout)
+	defer cancel()
+
+	if err := app.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+}
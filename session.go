@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+func newStreamSession(streamData *TwitchStreamData) *StreamSession {
+	startedAt, err := time.Parse(time.RFC3339, streamData.StartedAt)
+	if err != nil {
+		startedAt = time.Now()
+	}
+
+	session := &StreamSession{StreamID: streamData.ID, StartedAt: startedAt, MessagesEditedAt: time.Now()}
+	session.update(streamData)
+	session.NotifiedTitle = session.Title
+	session.NotifiedGame = session.GameName
+	return session
+}
+
+// update applies the latest stream data to the session and reports whether
+// anything shown in the live message changed.
+func (session *StreamSession) update(streamData *TwitchStreamData) bool {
+	changed := session.Title != streamData.Title ||
+		session.GameName != streamData.GameName ||
+		session.ViewerCount != streamData.ViewerCount
+
+	session.Title = streamData.Title
+	session.GameName = streamData.GameName
+	session.ViewerCount = streamData.ViewerCount
+	session.MissedChecks = 0
+	session.FirstMissAt = time.Time{}
+	if session.StreamID == "" {
+		session.StreamID = streamData.ID
+	}
+
+	if streamData.ViewerCount > session.PeakViewers {
+		session.PeakViewers = streamData.ViewerCount
+	}
+
+	if streamData.GameName != "" && !slices.Contains(session.Games, streamData.GameName) {
+		session.Games = append(session.Games, streamData.GameName)
+	}
+	if streamData.Title != "" && !slices.Contains(session.Titles, streamData.Title) {
+		session.Titles = append(session.Titles, streamData.Title)
+	}
+
+	session.ViewerSamples++
+	session.ViewerTotal += int64(streamData.ViewerCount)
+
+	return changed
+}
+
+// needsEdit reports whether the live messages should be edited for the
+// latest stream data, to be called before update: title and category changes
+// are shown right away, viewer counts at most every LiveMessageEditInterval.
+func (session *StreamSession) needsEdit(streamData *TwitchStreamData, now time.Time) bool {
+	if session.Title != streamData.Title || session.GameName != streamData.GameName {
+		return true
+	}
+	return session.ViewerCount != streamData.ViewerCount &&
+		now.Sub(session.MessagesEditedAt) >= LiveMessageEditInterval
+}
+
+// isDifferentBroadcast reports whether the stream data belongs to a new
+// broadcast rather than the session's one, comparing stream IDs when both are
+// known and start times otherwise.
+func (session *StreamSession) isDifferentBroadcast(streamData *TwitchStreamData) bool {
+	if session.StreamID != "" && streamData.ID != "" {
+		return session.StreamID != streamData.ID
+	}
+
+	startedAt, err := time.Parse(time.RFC3339, streamData.StartedAt)
+	return err == nil && !startedAt.Equal(session.StartedAt)
+}
+
+// pendingChanges reports whether the title or category differ from the last
+// announced values and the cooldown since the last announcement has elapsed.
+// Values that were never known (e.g. a session started from a bare EventSub
+// event) are adopted silently.
+func (session *StreamSession) pendingChanges(now time.Time, cooldown time.Duration) (titleChanged, gameChanged bool) {
+	if session.NotifiedTitle == "" {
+		session.NotifiedTitle = session.Title
+	}
+	if session.NotifiedGame == "" {
+		session.NotifiedGame = session.GameName
+	}
+
+	titleChanged = session.Title != session.NotifiedTitle
+	gameChanged = session.GameName != session.NotifiedGame
+	if now.Sub(session.LastChangeNotified) < cooldown {
+		return false, false
+	}
+	return titleChanged, gameChanged
+}
+
+func (session *StreamSession) clone() *StreamSession {
+	if session == nil {
+		return nil
+	}
+
+	sessionCopy := *session
+	sessionCopy.Games = append([]string(nil), session.Games...)
+	sessionCopy.Titles = append([]string(nil), session.Titles...)
+	sessionCopy.Messages = append([]LiveMessage(nil), session.Messages...)
+	return &sessionCopy
+}
+
+// record turns a finished session into a history entry.
+func (session *StreamSession) record(streamer *Streamer, endedAt time.Time) *SessionRecord {
+	record := &SessionRecord{
+		Username:    streamer.Username,
+		UserID:      streamer.UserID,
+		DisplayName: streamer.DisplayName,
+		StartedAt:   session.StartedAt,
+		EndedAt:     endedAt,
+		Titles:      append([]string(nil), session.Titles...),
+		Games:       append([]string(nil), session.Games...),
+		PeakViewers: session.PeakViewers,
+	}
+	if len(record.Titles) == 0 && session.Title != "" {
+		record.Titles = []string{session.Title}
+	}
+	if session.ViewerSamples > 0 {
+		record.AverageViewers = int(session.ViewerTotal / int64(session.ViewerSamples))
+	}
+	return record
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Slack Block Kit limits
+const (
+	slackMaxSectionLength = 3000
+	slackMaxFieldLength   = 2000
+)
+
+func (notifier *SlackNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	text := fmt.Sprintf("🔴 %s is now live!", slackLink(event.channelURL(), event.DisplayName))
+	if event.Title != "" {
+		text += "\n" + slackEscape(event.Title)
+	}
+
+	var fields []SlackText
+	if event.GameName != "" {
+		fields = append(fields, *slackMarkdown("*Game*\n" + slackEscape(event.GameName)))
+	}
+	fields = append(fields, *slackMarkdown(fmt.Sprintf("*Viewers*\n%d", event.ViewerCount)))
+
+	blocks := []SlackBlock{{Type: "section", Text: slackMarkdown(text)}, {Type: "section", Fields: fields}}
+	if event.ThumbnailURL != "" {
+		blocks = append(blocks, SlackBlock{Type: "image", ImageURL: event.ThumbnailURL, AltText: "Stream preview"})
+	}
+	blocks = append(blocks, slackWatchButton(event))
+
+	// Incoming webhook messages can't be edited afterwards
+	return nil, notifier.post(target.URL, SlackWebhookPayload{
+		Text:   fmt.Sprintf("%s is now live: %s", slackEscape(event.DisplayName), slackEscape(event.Title)),
+		Blocks: blocks,
+	})
+}
+
+func (notifier *SlackNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	text := fmt.Sprintf("⚫ %s went offline", slackLink(event.channelURL(), event.DisplayName))
+	if event.Title != "" {
+		text += "\n" + slackEscape(event.Title)
+	}
+
+	var fields []SlackText
+	if event.GameName != "" {
+		fields = append(fields, *slackMarkdown("*Game*\n" + slackEscape(event.GameName)))
+	}
+	if !event.StartedAt.IsZero() {
+		fields = append(fields, *slackMarkdown("*Duration*\n" + formatDuration(event.EndedAt.Sub(event.StartedAt))))
+	}
+
+	blocks := []SlackBlock{{Type: "section", Text: slackMarkdown(text)}}
+	if len(fields) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "section", Fields: fields})
+	}
+	return notifier.post(target.URL, SlackWebhookPayload{
+		Text:   slackEscape(event.DisplayName) + " went offline",
+		Blocks: blocks,
+	})
+}
+
+func (notifier *SlackNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	var lines []string
+	if event.GameChanged {
+		lines = append(lines, fmt.Sprintf("🎮 %s changed category to _%s_", slackLink(event.channelURL(), event.DisplayName), slackEscape(event.GameName)))
+	}
+	if event.TitleChanged {
+		lines = append(lines, fmt.Sprintf("📺 %s has a new title: %s", slackLink(event.channelURL(), event.DisplayName), slackEscape(event.Title)))
+	}
+
+	return notifier.post(target.URL, SlackWebhookPayload{
+		Text:   slackEscape(event.DisplayName) + " updated the stream",
+		Blocks: []SlackBlock{{Type: "section", Text: slackMarkdown(strings.Join(lines, "\n"))}, slackWatchButton(event)},
+	})
+}
+
+// post executes the webhook, see requestJSON.
+func (notifier *SlackNotifier) post(webhookURL string, payload SlackWebhookPayload) error {
+	return postJSON(notifier.ctx, notifier.client, webhookURL, nil, payload)
+}
+
+func slackMarkdown(text string) *SlackText {
+	return &SlackText{Type: "mrkdwn", Text: truncateText(text, slackMaxSectionLength)}
+}
+
+func slackWatchButton(event *NotificationEvent) SlackBlock {
+	return SlackBlock{Type: "actions", Elements: []SlackElement{{
+		Type: "button",
+		Text: &SlackText{Type: "plain_text", Text: "📺 Watch"},
+		URL:  event.channelURL(),
+	}}}
+}
+
+// slackEscape escapes the characters Slack treats as control sequences in
+// mrkdwn text and notification fallbacks, so a title can't ping the channel
+// with <!channel> or fake a link.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(truncateText(text, slackMaxFieldLength))
+}
+
+func slackLink(linkURL, text string) string {
+	return fmt.Sprintf("<%s|%s>", linkURL, slackEscape(text))
+}
+
+// slackWebhookID returns the team and channel parts of a Slack webhook URL
+// (https://hooks.slack.com/services/<team>/<channel>/<secret>).
+func slackWebhookID(webhookURL string) string {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/services/"), "/")
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// validateSlackWebhookURL checks that a URL is a Slack incoming webhook on
+// one of hosts, hooks.slack.com unless SLACK_WEBHOOK_HOSTS says otherwise
+// (e.g. for a local test server).
+func validateSlackWebhookURL(webhookURL string, hosts []string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(hosts, parsed.Host) {
+		return fmt.Errorf("not a Slack URL")
+	}
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/services/"), "/")
+	if parsed.Scheme != "https" || !strings.HasPrefix(parsed.Path, "/services/") || len(parts) != 3 || slices.Contains(parts, "") {
+		return fmt.Errorf("expected https://%s/services/<team>/<channel>/<secret>", parsed.Host)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateSlackWebhookURL(t *testing.T) {
+	defaultHosts := []string{DefaultSlackWebhookHost}
+	localHosts := []string{"127.0.0.1:8443"}
+
+	tests := []struct {
+		name    string
+		url     string
+		hosts   []string
+		wantErr bool
+	}{
+		{"slack", "https://hooks.slack.com/services/T0/B0/secret", defaultHosts, false},
+		{"other host", "https://example.com/services/T0/B0/secret", defaultHosts, true},
+		{"plain http", "http://hooks.slack.com/services/T0/B0/secret", defaultHosts, true},
+		{"missing secret", "https://hooks.slack.com/services/T0/B0", defaultHosts, true},
+		{"empty part", "https://hooks.slack.com/services/T0//secret", defaultHosts, true},
+		{"configured host", "https://127.0.0.1:8443/services/T0/B0/secret", localHosts, false},
+		{"slack not configured", "https://hooks.slack.com/services/T0/B0/secret", localHosts, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSlackWebhookURL(tt.url, tt.hosts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSlackWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSlackNotifierLive(t *testing.T) {
+	var payload SlackWebhookPayload
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/services/T0/B0/secret" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	webhookURL := server.URL + "/services/T0/B0/secret"
+	if err := validateSlackWebhookURL(webhookURL, []string{strings.TrimPrefix(server.URL, "https://")}); err != nil {
+		t.Fatalf("test server URL rejected: %v", err)
+	}
+
+	notifier := &SlackNotifier{ctx: context.Background(), client: server.Client()}
+	event := &NotificationEvent{
+		Username:     "streamer",
+		DisplayName:  "Streamer",
+		Title:        "<!channel> Q&A",
+		GameName:     "Just Chatting",
+		ViewerCount:  42,
+		ThumbnailURL: "https://example.com/thumb.jpg",
+	}
+	message, err := notifier.Live(NotificationTarget{Type: notifierSlack, URL: webhookURL}, event)
+	if err != nil {
+		t.Fatalf("Live() error = %v", err)
+	}
+	if message != nil {
+		t.Errorf("Live() returned an editable message for a webhook")
+	}
+
+	if want := "Streamer is now live: &lt;!channel&gt; Q&amp;A"; payload.Text != want {
+		t.Errorf("Text = %q, want %q", payload.Text, want)
+	}
+	if len(payload.Blocks) != 4 {
+		t.Fatalf("got %d blocks, want 4", len(payload.Blocks))
+	}
+	if want := "🔴 <https://twitch.tv/streamer|Streamer> is now live!\n&lt;!channel&gt; Q&amp;A"; payload.Blocks[0].Text == nil || payload.Blocks[0].Text.Text != want {
+		t.Errorf("header section = %+v, want text %q", payload.Blocks[0].Text, want)
+	}
+	if fields := payload.Blocks[1].Fields; len(fields) != 2 || fields[0].Text != "*Game*\nJust Chatting" || fields[1].Text != "*Viewers*\n42" {
+		t.Errorf("fields = %+v", fields)
+	}
+	if image := payload.Blocks[2]; image.Type != "image" || image.ImageURL != event.ThumbnailURL {
+		t.Errorf("image block = %+v", image)
+	}
+	if button := payload.Blocks[3]; button.Type != "actions" || len(button.Elements) != 1 || button.Elements[0].URL != "https://twitch.tv/streamer" {
+		t.Errorf("button block = %+v", button)
+	}
+}
+
+func TestSlackNotifierError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	notifier := &SlackNotifier{ctx: context.Background(), client: server.Client()}
+	err := notifier.Offline(NotificationTarget{Type: notifierSlack, URL: server.URL + "/services/T0/B0/secret"}, &NotificationEvent{Username: "streamer", DisplayName: "Streamer"})
+	if err == nil {
+		t.Fatal("Offline() succeeded on HTTP 403")
+	}
+	if isRetryableNotificationError(err) {
+		t.Errorf("HTTP 403 treated as retryable: %v", err)
+	}
+}
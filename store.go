@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+// openStore opens the storage backend selected by STORAGE_BACKEND.
+func openStore(config Config) (Store, error) {
+	switch config.StorageBackend {
+	case "sqlite":
+		log.Printf("Using SQLite storage at %s", config.SQLitePath)
+		return NewSQLiteStore(config.SQLitePath)
+	default:
+		log.Printf("Using JSON storage at %s", StreamersFilePath)
+		return NewJSONStore(StreamersFilePath, config.TelegramChatID)
+	}
+}
+
+// runMigrateCommand copies the streamers, subscriptions, sessions, chat
+// settings, stream history and notification outbox from the JSON file into a
+// SQLite database. It refuses to write into a database that already contains
+// streamers unless -force is given.
+func runMigrateCommand(args []string) error {
+	godotenv.Load()
+
+	sqlitePath := os.Getenv("SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = DefaultSQLitePath
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := flags.String("from", StreamersFilePath, "JSON streamers file to read")
+	to := flags.String("to", sqlitePath, "SQLite database to write")
+	force := flags.Bool("force", false, "overwrite streamers already present in the database, replacing its history and outbox")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*from); err != nil {
+		return fmt.Errorf("reading %s: %v", *from, err)
+	}
+
+	var legacyChatID int64
+	if env := os.Getenv("TELEGRAM_CHAT_ID"); env != "" {
+		id, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid TELEGRAM_CHAT_ID: %v", err)
+		}
+		legacyChatID = id
+	}
+
+	source, err := NewJSONStore(*from, legacyChatID)
+	if err != nil {
+		return err
+	}
+	streamers, err := source.ListStreamers()
+	if err != nil {
+		return err
+	}
+	chats, err := source.ListChats()
+	if err != nil {
+		return err
+	}
+
+	target, err := NewSQLiteStore(*to)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", *to, err)
+	}
+	defer target.Close()
+
+	existing, err := target.ListStreamers()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 && !*force {
+		return fmt.Errorf("%s already contains %d streamers, use -force to overwrite them", *to, len(existing))
+	}
+	if *force {
+		if err := target.clearHistoryAndOutbox(); err != nil {
+			return fmt.Errorf("clearing %s: %v", *to, err)
+		}
+	}
+
+	for i := range streamers {
+		if err := target.AddStreamer(&streamers[i]); err != nil {
+			return fmt.Errorf("migrating streamer %s: %v", streamers[i].Username, err)
+		}
+	}
+	for i := range chats {
+		if err := target.SaveChat(&chats[i]); err != nil {
+			return fmt.Errorf("migrating settings of chat %d: %v", chats[i].ChatID, err)
+		}
+	}
+
+	// Pending notifications are carried over with their streamer's status
+	outbox := make(map[string][]*OutboxEntry)
+	for i := range source.outbox {
+		entry := source.outbox[i]
+		outbox[entry.Username] = append(outbox[entry.Username], &entry)
+	}
+	for i := range streamers {
+		if entries := outbox[streamers[i].Username]; len(entries) > 0 {
+			if err := target.UpdateStreamerStatus(&streamers[i], entries); err != nil {
+				return fmt.Errorf("migrating outbox of %s: %v", streamers[i].Username, err)
+			}
+		}
+	}
+
+	for i := range source.history {
+		if err := target.AddSessionRecord(&source.history[i]); err != nil {
+			return fmt.Errorf("migrating history of %s: %v", source.history[i].Username, err)
+		}
+	}
+
+	log.Printf("Migrated %d streamers, %d chats, %d past streams and %d pending notifications from %s to %s",
+		len(streamers), len(chats), len(source.history), len(source.outbox), *from, *to)
+	log.Println("Set STORAGE_BACKEND=sqlite to use the new database")
+	return nil
+}
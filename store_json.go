@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// NewJSONStore loads the streamers file. Files written before per-chat
+// subscriptions existed are migrated by subscribing legacyChatID to every
+// streamer they contain. An unreadable file falls back to the backup of the
+// last good version; if that fails too an error is returned rather than
+// starting with an empty list.
+func NewJSONStore(filename string, legacyChatID int64) (*JSONStore, error) {
+	store := &JSONStore{
+		filename:     filename,
+		legacyChatID: legacyChatID,
+		streamers:    make(map[string]*Streamer),
+		chats:        make(map[int64]*ChatSettings),
+	}
+	if err := store.loadFromFile(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *JSONStore) loadFromFile() error {
+	backupFilename := store.filename + ".bak"
+
+	file, migrated, err := store.readStreamersFile(store.filename)
+	if os.IsNotExist(err) {
+		// A crash between rotating the backup and renaming the new file into
+		// place leaves only the backup behind.
+		file, migrated, err = store.readStreamersFile(backupFilename)
+		if os.IsNotExist(err) {
+			log.Println("Streamers file does not exist, starting with empty list")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("streamers file %s is missing and backup is unreadable: %v", store.filename, err)
+		}
+		log.Printf("Streamers file %s is missing, restored from backup", store.filename)
+		migrated = true
+	} else if err != nil {
+		log.Printf("Error loading streamers file %s: %v", store.filename, err)
+
+		var backupErr error
+		file, migrated, backupErr = store.readStreamersFile(backupFilename)
+		if backupErr != nil {
+			return fmt.Errorf("streamers file %s is unreadable (%v) and no usable backup exists (%v), refusing to start", store.filename, err, backupErr)
+		}
+
+		// Keep the broken file for inspection and so it isn't rotated into
+		// the backup by the next save.
+		corruptFilename := fmt.Sprintf("%s.corrupt-%d", store.filename, time.Now().Unix())
+		if err := os.Rename(store.filename, corruptFilename); err != nil {
+			return fmt.Errorf("failed to move aside unreadable streamers file: %v", err)
+		}
+		log.Printf("Restored streamers from backup %s, unreadable file moved to %s", backupFilename, corruptFilename)
+		migrated = true
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, chat := range file.Chats {
+		chatCopy := chat.clone()
+		store.chats[chatCopy.ChatID] = &chatCopy
+	}
+
+	store.history = file.History
+
+	store.outbox = file.Outbox
+	for _, entry := range store.outbox {
+		store.nextOutboxID = max(store.nextOutboxID, entry.ID)
+	}
+
+	seenUserIDs := make(map[string]bool)
+	for _, streamer := range file.Streamers {
+		if seenUserIDs[streamer.UserID] {
+			continue
+		}
+		seenUserIDs[streamer.UserID] = true
+		store.streamers[streamer.Username] = streamer.clone()
+	}
+
+	if migrated || len(file.Streamers) != len(store.streamers) {
+		if err := store.saveToFile(); err != nil {
+			log.Printf("Error saving streamers to file: %v", err)
+		}
+	}
+	return nil
+}
+
+func (store *JSONStore) readStreamersFile(filename string) (*StreamersFile, bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	return store.parseStreamersFile(data)
+}
+
+func (store *JSONStore) parseStreamersFile(data []byte) (*StreamersFile, bool, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		var file StreamersFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, false, err
+		}
+		return &file, false, nil
+	}
+
+	var streamers []Streamer
+	if err := json.Unmarshal(data, &streamers); err != nil {
+		return nil, false, err
+	}
+
+	if store.legacyChatID == 0 {
+		log.Println("Warning: migrating legacy streamers file without TELEGRAM_CHAT_ID, streamers will have no subscribers")
+	} else {
+		log.Printf("Migrating legacy streamers file, subscribing chat %d to %d streamers", store.legacyChatID, len(streamers))
+	}
+	for i := range streamers {
+		if store.legacyChatID != 0 {
+			streamers[i].Subscribers = []int64{store.legacyChatID}
+		}
+	}
+	return &StreamersFile{Streamers: streamers}, true, nil
+}
+
+func (store *JSONStore) saveToFile() error {
+	file := StreamersFile{
+		Version:   StreamersFileVersion,
+		Streamers: make([]Streamer, 0, len(store.streamers)),
+	}
+	for _, streamer := range store.streamers {
+		file.Streamers = append(file.Streamers, *streamer)
+	}
+	for _, chat := range store.chats {
+		file.Chats = append(file.Chats, *chat)
+	}
+	file.History = store.history
+	file.Outbox = store.outbox
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling JSON: %v", err)
+		return err
+	}
+
+	err = writeFileAtomic(store.filename, data, 0644)
+	if err != nil {
+		log.Printf("Error writing file %s: %v", store.filename, err)
+		return err
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// fsyncs it and renames it over filename, so readers only ever see the old or
+// the new contents. The previous version is kept as filename.bak.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filename, filename+".bak"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotating backup: %v", err)
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+
+	// Persist the renames themselves
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
+
+func (store *JSONStore) ListStreamers() ([]Streamer, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	streamers := make([]Streamer, 0, len(store.streamers))
+	for _, streamer := range store.streamers {
+		streamers = append(streamers, *streamer.clone())
+	}
+	return streamers, nil
+}
+
+func (store *JSONStore) ListChats() ([]ChatSettings, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	chats := make([]ChatSettings, 0, len(store.chats))
+	for _, chat := range store.chats {
+		chats = append(chats, chat.clone())
+	}
+	return chats, nil
+}
+
+func (store *JSONStore) AddStreamer(streamer *Streamer) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.streamers[streamer.Username] = streamer.clone()
+	return store.saveToFile()
+}
+
+func (store *JSONStore) RemoveStreamer(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.streamers, username)
+	return store.saveToFile()
+}
+
+func (store *JSONStore) UpdateStreamerStatus(streamer *Streamer, outbox []*OutboxEntry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, exists := store.streamers[streamer.Username]
+	if !exists {
+		return fmt.Errorf("streamer %s not found", streamer.Username)
+	}
+	stored.IsLive = streamer.IsLive
+	stored.LastChecked = streamer.LastChecked
+	stored.Session = streamer.Session.clone()
+	for _, entry := range outbox {
+		store.nextOutboxID++
+		entry.ID = store.nextOutboxID
+		store.outbox = append(store.outbox, *entry)
+	}
+	return store.saveToFile()
+}
+
+func (store *JSONStore) AddSubscriber(username string, chatID int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	streamer, exists := store.streamers[username]
+	if !exists {
+		return fmt.Errorf("streamer %s not found", username)
+	}
+	if slices.Contains(streamer.Subscribers, chatID) {
+		return nil
+	}
+	streamer.Subscribers = append(streamer.Subscribers, chatID)
+	return store.saveToFile()
+}
+
+func (store *JSONStore) RemoveSubscriber(username string, chatID int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	streamer, exists := store.streamers[username]
+	if !exists {
+		return fmt.Errorf("streamer %s not found", username)
+	}
+	streamer.Subscribers = slices.DeleteFunc(streamer.Subscribers, func(subscriber int64) bool {
+		return subscriber == chatID
+	})
+	return store.saveToFile()
+}
+
+func (store *JSONStore) SaveChat(chat *ChatSettings) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	chatCopy := chat.clone()
+	store.chats[chat.ChatID] = &chatCopy
+	return store.saveToFile()
+}
+
+func (store *JSONStore) AddSessionRecord(record *SessionRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.history = append(store.history, *record)
+	return store.saveToFile()
+}
+
+func (store *JSONStore) ListSessionRecords(username string, limit int) ([]SessionRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var records []SessionRecord
+	for i := len(store.history) - 1; i >= 0 && len(records) < limit; i-- {
+		if store.history[i].Username == username {
+			records = append(records, store.history[i])
+		}
+	}
+	return records, nil
+}
+
+func (store *JSONStore) PruneSessionRecords(username string, keep int, cutoff time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// History is appended in order, so the newest sessions are at the end
+	kept := 0
+	history := make([]SessionRecord, 0, len(store.history))
+	for i := len(store.history) - 1; i >= 0; i-- {
+		record := store.history[i]
+		if !cutoff.IsZero() && record.EndedAt.Before(cutoff) {
+			continue
+		}
+		if record.Username == username {
+			if kept >= keep {
+				continue
+			}
+			kept++
+		}
+		history = append(history, record)
+	}
+	slices.Reverse(history)
+
+	if len(history) == len(store.history) {
+		return nil
+	}
+	store.history = history
+	return store.saveToFile()
+}
+
+func (store *JSONStore) ListOutboxEntries() ([]OutboxEntry, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return slices.Clone(store.outbox), nil
+}
+
+func (store *JSONStore) UpdateOutboxEntry(entry *OutboxEntry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	i := slices.IndexFunc(store.outbox, func(stored OutboxEntry) bool {
+		return stored.ID == entry.ID
+	})
+	if i < 0 {
+		return fmt.Errorf("outbox entry %d not found", entry.ID)
+	}
+	store.outbox[i] = *entry
+	return store.saveToFile()
+}
+
+func (store *JSONStore) RemoveOutboxEntry(id int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.outbox = slices.DeleteFunc(store.outbox, func(entry OutboxEntry) bool {
+		return entry.ID == id
+	})
+	return store.saveToFile()
+}
+
+func (store *JSONStore) Close() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testStreamersFile = `{"version": 2, "streamers": [{"username": "streamer", "display_name": "Streamer", "user_id": "42", "subscribers": [7]}]}`
+
+func writeTestFile(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatalf("writing %s: %v", filename, err)
+	}
+}
+
+// readStreamersFileAt parses a streamers file as saved by the store.
+func readStreamersFileAt(t *testing.T, filename string) StreamersFile {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("reading %s: %v", filename, err)
+	}
+	var file StreamersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("%s is not a valid streamers file: %v", filename, err)
+	}
+	return file
+}
+
+func assertSingleStreamer(t *testing.T, store *JSONStore, subscribers []int64) {
+	t.Helper()
+	streamers, err := store.ListStreamers()
+	if err != nil {
+		t.Fatalf("ListStreamers() error = %v", err)
+	}
+	if len(streamers) != 1 || streamers[0].Username != "streamer" || !slices.Equal(streamers[0].Subscribers, subscribers) {
+		t.Fatalf("streamers = %+v, want streamer subscribed by %v", streamers, subscribers)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+
+	if err := writeFileAtomic(filename, []byte("first"), 0644); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := writeFileAtomic(filename, []byte("second"), 0644); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+
+	if data, _ := os.ReadFile(filename); string(data) != "second" {
+		t.Errorf("file = %q, want second", data)
+	}
+	if data, _ := os.ReadFile(filename + ".bak"); string(data) != "first" {
+		t.Errorf("backup = %q, want first", data)
+	}
+	if leftovers, _ := filepath.Glob(filename + ".tmp-*"); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestJSONStoreRestoresCorruptFileFromBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+	writeTestFile(t, filename, `{"version": 2, "streamers": [`)
+	writeTestFile(t, filename+".bak", testStreamersFile)
+
+	store, err := NewJSONStore(filename, 0)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	assertSingleStreamer(t, store, []int64{7})
+
+	// The broken file is kept aside and the main file is valid again
+	corrupt, _ := filepath.Glob(filename + ".corrupt-*")
+	if len(corrupt) != 1 {
+		t.Fatalf("corrupt files = %v, want one", corrupt)
+	}
+	if data, _ := os.ReadFile(corrupt[0]); string(data) != `{"version": 2, "streamers": [` {
+		t.Errorf("corrupt file = %q, want the original contents", data)
+	}
+	if file := readStreamersFileAt(t, filename); len(file.Streamers) != 1 {
+		t.Errorf("rewritten file has %d streamers, want 1", len(file.Streamers))
+	}
+}
+
+func TestJSONStoreRefusesToStartWithoutUsableFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+	writeTestFile(t, filename, `{"streamers": [`)
+	writeTestFile(t, filename+".bak", `not json`)
+
+	if _, err := NewJSONStore(filename, 0); err == nil {
+		t.Fatal("NewJSONStore() succeeded with both files unreadable")
+	}
+
+	// Nothing is overwritten, so the files can still be repaired by hand
+	if data, _ := os.ReadFile(filename); string(data) != `{"streamers": [` {
+		t.Errorf("streamers file = %q, want it untouched", data)
+	}
+	if data, _ := os.ReadFile(filename + ".bak"); string(data) != `not json` {
+		t.Errorf("backup = %q, want it untouched", data)
+	}
+}
+
+func TestJSONStoreRestoresMissingFileFromBackup(t *testing.T) {
+	// A crash between rotating the backup and renaming the new file
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+	writeTestFile(t, filename+".bak", testStreamersFile)
+
+	store, err := NewJSONStore(filename, 0)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	assertSingleStreamer(t, store, []int64{7})
+
+	if file := readStreamersFileAt(t, filename); len(file.Streamers) != 1 {
+		t.Errorf("restored file has %d streamers, want 1", len(file.Streamers))
+	}
+}
+
+func TestJSONStoreStartsEmptyWithoutFiles(t *testing.T) {
+	store, err := NewJSONStore(filepath.Join(t.TempDir(), "streamers.json"), 0)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	if streamers, _ := store.ListStreamers(); len(streamers) != 0 {
+		t.Errorf("streamers = %+v, want none", streamers)
+	}
+}
+
+func TestJSONStoreMigratesLegacyArray(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "streamers.json")
+	legacy := `[{"username": "streamer", "display_name": "Streamer", "user_id": "42"}, {"username": "dupe", "display_name": "Dupe", "user_id": "42"}]`
+	writeTestFile(t, filename, legacy)
+
+	store, err := NewJSONStore(filename, 7)
+	if err != nil {
+		t.Fatalf("NewJSONStore() error = %v", err)
+	}
+	assertSingleStreamer(t, store, []int64{7})
+
+	file := readStreamersFileAt(t, filename)
+	if file.Version != StreamersFileVersion || len(file.Streamers) != 1 {
+		t.Errorf("migrated file = version %d with %d streamers, want version %d with 1", file.Version, len(file.Streamers), StreamersFileVersion)
+	}
+	if data, _ := os.ReadFile(filename + ".bak"); string(data) != legacy {
+		t.Errorf("backup = %q, want the legacy file", data)
+	}
+}
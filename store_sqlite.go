@@ -0,0 +1,503 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is the initial schema (version 1), later changed by
+// sqliteMigrations.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS streamers (
+	username     TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	display_name TEXT NOT NULL,
+	is_live      INTEGER NOT NULL DEFAULT 0,
+	last_checked TEXT NOT NULL DEFAULT '',
+	session      TEXT
+);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	username TEXT NOT NULL REFERENCES streamers(username) ON DELETE CASCADE,
+	chat_id  INTEGER NOT NULL,
+	PRIMARY KEY (username, chat_id)
+);
+CREATE TABLE IF NOT EXISTS chats (
+	chat_id  INTEGER PRIMARY KEY,
+	settings TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	username        TEXT NOT NULL,
+	user_id         TEXT NOT NULL,
+	display_name    TEXT NOT NULL,
+	started_at      TEXT NOT NULL,
+	ended_at        TEXT NOT NULL,
+	titles          TEXT NOT NULL,
+	games           TEXT NOT NULL,
+	peak_viewers    INTEGER NOT NULL,
+	average_viewers INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_username_ended_at ON sessions (username, ended_at);
+CREATE TABLE IF NOT EXISTS outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind            TEXT NOT NULL,
+	chat_id         INTEGER NOT NULL,
+	username        TEXT NOT NULL,
+	user_id         TEXT NOT NULL,
+	session_start   TEXT NOT NULL DEFAULT '',
+	text            TEXT NOT NULL,
+	thumbnail_url   TEXT NOT NULL DEFAULT '',
+	created_at      TEXT NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TEXT NOT NULL DEFAULT '',
+	last_error      TEXT NOT NULL DEFAULT ''
+);
+`
+
+// sqliteMigrations bring the schema from one version to the next, the
+// version being kept in PRAGMA user_version: step i upgrades a database at
+// version i. Steps that shipped must never change, add a new one instead.
+var sqliteMigrations = []func(tx *sql.Tx) error{
+	// Databases created before versioning have some prefix of the initial
+	// tables, which CREATE TABLE IF NOT EXISTS completes
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(sqliteSchema)
+		return err
+	},
+	migrateOutboxTargets,
+}
+
+// migrateOutboxTargets replaces the rendered Telegram text of outbox entries
+// with a target and an event, so entries can be sent through any notifier.
+func migrateOutboxTargets(tx *sql.Tx) error {
+	var legacy int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('outbox') WHERE name = 'text'`).Scan(&legacy); err != nil {
+		return err
+	}
+	if legacy == 0 {
+		// Created unversioned by a build that already had the new columns
+		return nil
+	}
+
+	// Rendered messages can't be turned back into events
+	var pending int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&pending); err != nil {
+		return err
+	}
+	if pending > 0 {
+		log.Printf("Discarding %d pending notifications queued by a previous version", pending)
+	}
+
+	_, err := tx.Exec(`
+DROP TABLE outbox;
+CREATE TABLE outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind            TEXT NOT NULL,
+	chat_id         INTEGER NOT NULL,
+	username        TEXT NOT NULL,
+	user_id         TEXT NOT NULL,
+	session_start   TEXT NOT NULL DEFAULT '',
+	target          TEXT NOT NULL,
+	event           TEXT NOT NULL,
+	created_at      TEXT NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TEXT NOT NULL DEFAULT '',
+	last_error      TEXT NOT NULL DEFAULT ''
+);`)
+	return err
+}
+
+// sqliteTimeFormat is fixed width so timestamps compare correctly as text.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writers anyway; a single connection avoids
+	// SQLITE_BUSY between our own goroutines.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLiteSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrateSQLiteSchema runs the migrations the database hasn't seen yet, each
+// in its own transaction together with the version bump.
+func migrateSQLiteSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := sqliteMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("version %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *SQLiteStore) ListStreamers() ([]Streamer, error) {
+	rows, err := store.db.Query(`SELECT username, user_id, display_name, is_live, last_checked, session FROM streamers ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streamers []Streamer
+	indexes := make(map[string]int)
+	for rows.Next() {
+		var streamer Streamer
+		var lastChecked string
+		var session sql.NullString
+		if err := rows.Scan(&streamer.Username, &streamer.UserID, &streamer.DisplayName, &streamer.IsLive, &lastChecked, &session); err != nil {
+			return nil, err
+		}
+		if lastChecked != "" {
+			if streamer.LastChecked, err = time.Parse(time.RFC3339Nano, lastChecked); err != nil {
+				return nil, fmt.Errorf("streamer %s: invalid last_checked: %v", streamer.Username, err)
+			}
+		}
+		if session.Valid {
+			if err := json.Unmarshal([]byte(session.String), &streamer.Session); err != nil {
+				return nil, fmt.Errorf("streamer %s: invalid session: %v", streamer.Username, err)
+			}
+		}
+		indexes[streamer.Username] = len(streamers)
+		streamers = append(streamers, streamer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	subscriptions, err := store.db.Query(`SELECT username, chat_id FROM subscriptions ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer subscriptions.Close()
+
+	for subscriptions.Next() {
+		var username string
+		var chatID int64
+		if err := subscriptions.Scan(&username, &chatID); err != nil {
+			return nil, err
+		}
+		if i, exists := indexes[username]; exists {
+			streamers[i].Subscribers = append(streamers[i].Subscribers, chatID)
+		}
+	}
+	return streamers, subscriptions.Err()
+}
+
+func (store *SQLiteStore) ListChats() ([]ChatSettings, error) {
+	rows, err := store.db.Query(`SELECT settings FROM chats ORDER BY chat_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []ChatSettings
+	for rows.Next() {
+		var settings string
+		if err := rows.Scan(&settings); err != nil {
+			return nil, err
+		}
+		var chat ChatSettings
+		if err := json.Unmarshal([]byte(settings), &chat); err != nil {
+			return nil, fmt.Errorf("invalid chat settings: %v", err)
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// AddStreamer inserts or replaces a streamer along with its status, session
+// and subscribers.
+func (store *SQLiteStore) AddStreamer(streamer *Streamer) error {
+	session, err := encodeSession(streamer.Session)
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO streamers (username, user_id, display_name, is_live, last_checked, session)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET user_id = excluded.user_id, display_name = excluded.display_name,
+			is_live = excluded.is_live, last_checked = excluded.last_checked, session = excluded.session`,
+		streamer.Username, streamer.UserID, streamer.DisplayName, streamer.IsLive, formatTimestamp(streamer.LastChecked), session)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM subscriptions WHERE username = ?`, streamer.Username); err != nil {
+		return err
+	}
+	for _, chatID := range streamer.Subscribers {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO subscriptions (username, chat_id) VALUES (?, ?)`, streamer.Username, chatID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) RemoveStreamer(username string) error {
+	_, err := store.db.Exec(`DELETE FROM streamers WHERE username = ?`, username)
+	return err
+}
+
+func (store *SQLiteStore) UpdateStreamerStatus(streamer *Streamer, outbox []*OutboxEntry) error {
+	session, err := encodeSession(streamer.Session)
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE streamers SET is_live = ?, last_checked = ?, session = ? WHERE username = ?`,
+		streamer.IsLive, formatTimestamp(streamer.LastChecked), session, streamer.Username)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("streamer %s not found", streamer.Username)
+	}
+
+	for _, entry := range outbox {
+		target, err := json.Marshal(entry.Target)
+		if err != nil {
+			return err
+		}
+		event, err := json.Marshal(entry.Event)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`INSERT INTO outbox (kind, chat_id, username, user_id, session_start, target, event, created_at, attempts, next_attempt_at, last_error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.Kind, entry.ChatID, entry.Username, entry.UserID, formatTimestamp(entry.SessionStart), string(target), string(event),
+			formatTimestamp(entry.CreatedAt), entry.Attempts, formatTimestamp(entry.NextAttemptAt), entry.LastError)
+		if err != nil {
+			return err
+		}
+		if entry.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) AddSubscriber(username string, chatID int64) error {
+	_, err := store.db.Exec(`INSERT OR IGNORE INTO subscriptions (username, chat_id) VALUES (?, ?)`, username, chatID)
+	return err
+}
+
+func (store *SQLiteStore) RemoveSubscriber(username string, chatID int64) error {
+	_, err := store.db.Exec(`DELETE FROM subscriptions WHERE username = ? AND chat_id = ?`, username, chatID)
+	return err
+}
+
+func (store *SQLiteStore) SaveChat(chat *ChatSettings) error {
+	settings, err := json.Marshal(chat)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(`INSERT INTO chats (chat_id, settings) VALUES (?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET settings = excluded.settings`, chat.ChatID, string(settings))
+	return err
+}
+
+func (store *SQLiteStore) AddSessionRecord(record *SessionRecord) error {
+	titles, err := json.Marshal(record.Titles)
+	if err != nil {
+		return err
+	}
+	games, err := json.Marshal(record.Games)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(`INSERT INTO sessions (username, user_id, display_name, started_at, ended_at, titles, games, peak_viewers, average_viewers)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Username, record.UserID, record.DisplayName, formatTimestamp(record.StartedAt), formatTimestamp(record.EndedAt),
+		string(titles), string(games), record.PeakViewers, record.AverageViewers)
+	return err
+}
+
+func (store *SQLiteStore) ListSessionRecords(username string, limit int) ([]SessionRecord, error) {
+	rows, err := store.db.Query(`SELECT username, user_id, display_name, started_at, ended_at, titles, games, peak_viewers, average_viewers
+		FROM sessions WHERE username = ? ORDER BY ended_at DESC, id DESC LIMIT ?`, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []SessionRecord
+	for rows.Next() {
+		var record SessionRecord
+		var startedAt, endedAt, titles, games string
+		if err := rows.Scan(&record.Username, &record.UserID, &record.DisplayName, &startedAt, &endedAt,
+			&titles, &games, &record.PeakViewers, &record.AverageViewers); err != nil {
+			return nil, err
+		}
+		if record.StartedAt, err = time.Parse(time.RFC3339Nano, startedAt); err != nil {
+			return nil, fmt.Errorf("session of %s: invalid started_at: %v", username, err)
+		}
+		if record.EndedAt, err = time.Parse(time.RFC3339Nano, endedAt); err != nil {
+			return nil, fmt.Errorf("session of %s: invalid ended_at: %v", username, err)
+		}
+		if err := json.Unmarshal([]byte(titles), &record.Titles); err != nil {
+			return nil, fmt.Errorf("session of %s: invalid titles: %v", username, err)
+		}
+		if err := json.Unmarshal([]byte(games), &record.Games); err != nil {
+			return nil, fmt.Errorf("session of %s: invalid games: %v", username, err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (store *SQLiteStore) PruneSessionRecords(username string, keep int, cutoff time.Time) error {
+	if !cutoff.IsZero() {
+		if _, err := store.db.Exec(`DELETE FROM sessions WHERE ended_at < ?`, formatTimestamp(cutoff)); err != nil {
+			return err
+		}
+	}
+
+	_, err := store.db.Exec(`DELETE FROM sessions WHERE username = ? AND id NOT IN (
+		SELECT id FROM sessions WHERE username = ? ORDER BY ended_at DESC, id DESC LIMIT ?)`, username, username, keep)
+	return err
+}
+
+func (store *SQLiteStore) ListOutboxEntries() ([]OutboxEntry, error) {
+	rows, err := store.db.Query(`SELECT id, kind, chat_id, username, user_id, session_start, target, event, created_at, attempts, next_attempt_at, last_error
+		FROM outbox ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var sessionStart, target, event, createdAt, nextAttemptAt string
+		if err := rows.Scan(&entry.ID, &entry.Kind, &entry.ChatID, &entry.Username, &entry.UserID, &sessionStart, &target,
+			&event, &createdAt, &entry.Attempts, &nextAttemptAt, &entry.LastError); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(target), &entry.Target); err != nil {
+			return nil, fmt.Errorf("outbox entry %d: invalid target: %v", entry.ID, err)
+		}
+		if err := json.Unmarshal([]byte(event), &entry.Event); err != nil {
+			return nil, fmt.Errorf("outbox entry %d: invalid event: %v", entry.ID, err)
+		}
+		if entry.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+			return nil, fmt.Errorf("outbox entry %d: invalid created_at: %v", entry.ID, err)
+		}
+		if sessionStart != "" {
+			if entry.SessionStart, err = time.Parse(time.RFC3339Nano, sessionStart); err != nil {
+				return nil, fmt.Errorf("outbox entry %d: invalid session_start: %v", entry.ID, err)
+			}
+		}
+		if nextAttemptAt != "" {
+			if entry.NextAttemptAt, err = time.Parse(time.RFC3339Nano, nextAttemptAt); err != nil {
+				return nil, fmt.Errorf("outbox entry %d: invalid next_attempt_at: %v", entry.ID, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (store *SQLiteStore) UpdateOutboxEntry(entry *OutboxEntry) error {
+	result, err := store.db.Exec(`UPDATE outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		entry.Attempts, formatTimestamp(entry.NextAttemptAt), entry.LastError, entry.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("outbox entry %d not found", entry.ID)
+	}
+	return nil
+}
+
+func (store *SQLiteStore) RemoveOutboxEntry(id int64) error {
+	_, err := store.db.Exec(`DELETE FROM outbox WHERE id = ?`, id)
+	return err
+}
+
+// clearHistoryAndOutbox deletes the stream history and pending
+// notifications, which unlike streamers and chats can't be upserted, so that
+// a forced migration replaces them instead of adding duplicates.
+func (store *SQLiteStore) clearHistoryAndOutbox() error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sessions`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteStore) Close() error {
+	return store.db.Close()
+}
+
+func encodeSession(session *StreamSession) (sql.NullString, error) {
+	if session == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqliteTimeFormat)
+}
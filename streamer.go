@@ -1,103 +1,124 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
+	"slices"
 	"time"
 )
 
-func NewStreamerManager(filename string) *StreamerManager {
-	sm := &StreamerManager{
-		streamers: make(map[string]*Streamer),
-		filename:  filename,
+// NewStreamerManager loads the streamers and chat settings from the store and
+// keeps them in memory, writing every change through to the store.
+func NewStreamerManager(store Store) (*StreamerManager, error) {
+	streamers, err := store.ListStreamers()
+	if err != nil {
+		return nil, fmt.Errorf("listing streamers: %v", err)
 	}
-	sm.loadFromFile()
-	return sm
-}
-
-func (sm *StreamerManager) loadFromFile() {
-	data, err := os.ReadFile(sm.filename)
+	chats, err := store.ListChats()
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Println("Streamers file does not exist, starting with empty list")
-			return
-		}
-		log.Printf("Error reading streamers file: %v", err)
-		return
+		return nil, fmt.Errorf("listing chats: %v", err)
 	}
 
-	var streamers []Streamer
-	if err := json.Unmarshal(data, &streamers); err != nil {
-		log.Printf("Error unmarshalling streamers: %v", err)
-		return
+	sm := &StreamerManager{
+		streamers: make(map[string]*Streamer, len(streamers)),
+		chats:     make(map[int64]*ChatSettings, len(chats)),
+		store:     store,
+	}
+	for _, streamer := range streamers {
+		sm.streamers[streamer.Username] = streamer.clone()
+	}
+	for _, chat := range chats {
+		chatCopy := chat.clone()
+		sm.chats[chatCopy.ChatID] = &chatCopy
 	}
+	return sm, nil
+}
 
+func (sm *StreamerManager) addStreamer(streamer *Streamer) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	seenUserIDs := make(map[string]bool)
-	for _, streamer := range streamers {
-		if seenUserIDs[streamer.UserID] {
-			continue
-		}
-		seenUserIDs[streamer.UserID] = true
+	sm.streamers[streamer.Username] = streamer
+	return logStoreError(sm.store.AddStreamer(streamer), streamer.Username, "saving streamer")
+}
+
+func (sm *StreamerManager) removeStreamer(username string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(sm.streamers, username)
+	return logStoreError(sm.store.RemoveStreamer(username), username, "removing streamer")
+}
+
+func (sm *StreamerManager) addSubscriber(username string, chatID int64) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 
-		streamerCopy := streamer
-		sm.streamers[streamerCopy.Username] = &streamerCopy
+	streamer, exists := sm.streamers[username]
+	if !exists {
+		return fmt.Errorf("streamer %s not found", username)
 	}
 
-	if len(streamers) != len(sm.streamers) {
-		if err := sm.saveToFile(); err != nil {
-			log.Printf("Error saving streamers to file: %v", err)
+	for _, subscriber := range streamer.Subscribers {
+		if subscriber == chatID {
+			return nil
 		}
 	}
+	streamer.Subscribers = append(streamer.Subscribers, chatID)
+	return logStoreError(sm.store.AddSubscriber(username, chatID), username, "subscribing to")
 }
 
-func (sm *StreamerManager) saveToFile() error {
-	streamers := make([]Streamer, 0, len(sm.streamers))
-	for _, streamer := range sm.streamers {
-		streamers = append(streamers, *streamer)
-	}
+// removeSubscriber unsubscribes a chat from a streamer and drops the streamer
+// entirely once no chat is left. It reports whether the streamer was dropped.
+func (sm *StreamerManager) removeSubscriber(username string, chatID int64) (bool, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
 
-	data, err := json.MarshalIndent(streamers, "", "  ")
-	if err != nil {
-		log.Printf("Error marshaling JSON: %v", err)
-		return err
+	streamer, exists := sm.streamers[username]
+	if !exists {
+		return false, fmt.Errorf("streamer %s not found", username)
 	}
 
-	err = os.WriteFile(sm.filename, data, 0644)
-	if err != nil {
-		log.Printf("Error writing file %s: %v", sm.filename, err)
-		return err
+	subscribers := make([]int64, 0, len(streamer.Subscribers))
+	for _, subscriber := range streamer.Subscribers {
+		if subscriber != chatID {
+			subscribers = append(subscribers, subscriber)
+		}
 	}
+	streamer.Subscribers = subscribers
 
-	return nil
+	if len(subscribers) == 0 {
+		delete(sm.streamers, username)
+		return true, logStoreError(sm.store.RemoveStreamer(username), username, "removing streamer")
+	}
+	return false, logStoreError(sm.store.RemoveSubscriber(username, chatID), username, "unsubscribing from")
 }
 
-func (sm *StreamerManager) addStreamer(streamer *Streamer) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+func (sm *StreamerManager) getSubscribers(username string) []int64 {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
 
-	sm.streamers[streamer.Username] = streamer
-	return sm.saveToFileWithLog(streamer.Username, "saving file for streamer")
+	streamer, exists := sm.streamers[username]
+	if !exists {
+		return nil
+	}
+	return append([]int64(nil), streamer.Subscribers...)
 }
 
-func (sm *StreamerManager) removeStreamer(username string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	delete(sm.streamers, username)
-	return sm.saveToFileWithLog(username, "saving file after removing")
+func (sm *StreamerManager) isSubscribed(username string, chatID int64) bool {
+	for _, subscriber := range sm.getSubscribers(username) {
+		if subscriber == chatID {
+			return true
+		}
+	}
+	return false
 }
 
-func (sm *StreamerManager) saveToFileWithLog(context, action string) error {
-	if err := sm.saveToFile(); err != nil {
+func logStoreError(err error, context, action string) error {
+	if err != nil {
 		log.Printf("Error %s %s: %v", action, context, err)
-		return err
 	}
-	return nil
+	return err
 }
 
 func (sm *StreamerManager) getStreamers() []*Streamer {
@@ -110,15 +131,157 @@ func (sm *StreamerManager) getStreamers() []*Streamer {
 	return streamers
 }
 
-func (sm *StreamerManager) updateStreamerStatus(userID string, isLive bool) error {
+func (sm *StreamerManager) getStreamersForChat(chatID int64) []*Streamer {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	var streamers []*Streamer
+	for _, streamer := range sm.streamers {
+		for _, subscriber := range streamer.Subscribers {
+			if subscriber == chatID {
+				streamers = append(streamers, streamer)
+				break
+			}
+		}
+	}
+	return streamers
+}
+
+// updateStreamerStatus saves the status of a streamer along with the
+// notifications it triggered, which get their IDs assigned by the store.
+func (sm *StreamerManager) updateStreamerStatus(userID string, isLive bool, session *StreamSession, outbox ...*OutboxEntry) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	for _, streamer := range sm.streamers {
 		if streamer.UserID == userID {
 			streamer.IsLive = isLive
 			streamer.LastChecked = time.Now()
-			return sm.saveToFile()
+			streamer.Session = session.clone()
+			return sm.store.UpdateStreamerStatus(streamer, outbox)
 		}
 	}
 	return fmt.Errorf("streamer with userID %s not found", userID)
 }
+
+func (sm *StreamerManager) listOutbox() ([]OutboxEntry, error) {
+	return sm.store.ListOutboxEntries()
+}
+
+func (sm *StreamerManager) updateOutboxEntry(entry *OutboxEntry) error {
+	return logStoreError(sm.store.UpdateOutboxEntry(entry), entry.Username, "updating notification for")
+}
+
+func (sm *StreamerManager) removeOutboxEntry(entry *OutboxEntry) error {
+	return logStoreError(sm.store.RemoveOutboxEntry(entry.ID), entry.Username, "removing notification for")
+}
+
+func (sm *StreamerManager) getSession(userID string) *StreamSession {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	for _, streamer := range sm.streamers {
+		if streamer.UserID == userID {
+			return streamer.Session.clone()
+		}
+	}
+	return nil
+}
+
+// addSessionRecord stores a finished session and applies the retention
+// limits to the streamer's history.
+func (sm *StreamerManager) addSessionRecord(record *SessionRecord, keep int, maxAge time.Duration) error {
+	if err := sm.store.AddSessionRecord(record); err != nil {
+		return logStoreError(err, record.Username, "saving session of")
+	}
+
+	var cutoff time.Time
+	if maxAge > 0 {
+		cutoff = time.Now().Add(-maxAge)
+	}
+	return logStoreError(sm.store.PruneSessionRecords(record.Username, keep, cutoff), record.Username, "pruning history of")
+}
+
+func (sm *StreamerManager) getSessionHistory(username string, limit int) ([]SessionRecord, error) {
+	return sm.store.ListSessionRecords(username, limit)
+}
+
+func (sm *StreamerManager) getChatSettings(chatID int64) ChatSettings {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	chat, exists := sm.chats[chatID]
+	if !exists {
+		return ChatSettings{ChatID: chatID}
+	}
+	return chat.clone()
+}
+
+// updateChatSettings applies update to the settings of a chat, creating them
+// if needed, and persists the result.
+func (sm *StreamerManager) updateChatSettings(chatID int64, update func(chat *ChatSettings)) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	chat, exists := sm.chats[chatID]
+	if !exists {
+		chat = &ChatSettings{ChatID: chatID}
+		sm.chats[chatID] = chat
+	}
+	update(chat)
+
+	return logStoreError(sm.store.SaveChat(chat), fmt.Sprintf("%d", chatID), "saving settings of chat")
+}
+
+func (streamer *Streamer) clone() *Streamer {
+	streamerCopy := *streamer
+	streamerCopy.Subscribers = append([]int64(nil), streamer.Subscribers...)
+	streamerCopy.Session = streamer.Session.clone()
+	return &streamerCopy
+}
+
+func (chat ChatSettings) clone() ChatSettings {
+	chatCopy := chat
+	chatCopy.OfflineNotifications = cloneBool(chat.OfflineNotifications)
+	chatCopy.UpdateNotifications = cloneBool(chat.UpdateNotifications)
+	if chat.Streamers != nil {
+		chatCopy.Streamers = make(map[string]*StreamerPrefs, len(chat.Streamers))
+		for username, prefs := range chat.Streamers {
+			prefsCopy := *prefs
+			prefsCopy.OfflineNotifications = cloneBool(prefs.OfflineNotifications)
+			prefsCopy.UpdateNotifications = cloneBool(prefs.UpdateNotifications)
+			prefsCopy.Targets = slices.Clone(prefs.Targets)
+			chatCopy.Streamers[username] = &prefsCopy
+		}
+	}
+	return chatCopy
+}
+
+// streamerPrefs returns the preferences of a chat for a streamer, creating
+// them if needed.
+func (chat *ChatSettings) streamerPrefs(username string) *StreamerPrefs {
+	if chat.Streamers == nil {
+		chat.Streamers = make(map[string]*StreamerPrefs)
+	}
+	prefs, exists := chat.Streamers[username]
+	if !exists {
+		prefs = &StreamerPrefs{}
+		chat.Streamers[username] = prefs
+	}
+	return prefs
+}
+
+func cloneBool(value *bool) *bool {
+	if value == nil {
+		return nil
+	}
+	valueCopy := *value
+	return &valueCopy
+}
+
+func (prefs *StreamerPrefs) isEmpty() bool {
+	return prefs.Template == "" && prefs.OfflineNotifications == nil && prefs.UpdateNotifications == nil && len(prefs.Targets) == 0
+}
+
+func (chat *ChatSettings) pruneStreamerPrefs(username string) {
+	if prefs, exists := chat.Streamers[username]; exists && prefs.isEmpty() {
+		delete(chat.Streamers, username)
+	}
+}
@@ -3,34 +3,197 @@ package main
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func (app *App) sendNotification(streamer *Streamer, streamData *TwitchStreamResponse) error {
-	message := fmt.Sprintf("🔴 %s is now live!\n\n", streamer.DisplayName)
+func formatEndedText(streamer *Streamer, session *StreamSession, endedAt time.Time) string {
+	message := fmt.Sprintf("⚫ %s was live\n\n", htmlChannelLink(streamer))
 
-	if streamData != nil && len(streamData.Data) > 0 {
-		stream := streamData.Data[0]
-		message += fmt.Sprintf("📺 %s\n🎮 %s\n👥 %d viewers\n\n", stream.Title, stream.GameName, stream.ViewerCount)
+	if session.Title != "" {
+		message += fmt.Sprintf("📺 %s\n", escapeHTML(session.Title))
 	}
+	if len(session.Games) > 0 {
+		message += fmt.Sprintf("🎮 %s\n", htmlItalic(strings.Join(session.Games, ", ")))
+	}
+	message += fmt.Sprintf("⏱️ %s\n👥 %d peak viewers\n\n", formatDuration(endedAt.Sub(session.StartedAt)), session.PeakViewers)
+
+	message += fmt.Sprintf("🔗 %s", escapeHTML("https://twitch.tv/"+streamer.Username))
+	return message
+}
+
+// Live sends the notification rendered with the chat's template.
+func (notifier *TelegramNotifier) Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error) {
+	text := notifier.app.renderLiveText(target.ChatID, event)
+	message, err := notifier.app.sendLiveMessage(target.ChatID, text, event.ThumbnailURL, event.Username)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Offline tells the chat that a stream ended, with its duration and last
+// known title and category.
+func (notifier *TelegramNotifier) Offline(target NotificationTarget, event *NotificationEvent) error {
+	message := fmt.Sprintf("⚫ %s went offline\n\n", htmlChannelLink(event.streamer()))
+	if event.Title != "" {
+		message += fmt.Sprintf("📺 %s\n", escapeHTML(event.Title))
+	}
+	if event.GameName != "" {
+		message += fmt.Sprintf("🎮 %s\n", htmlItalic(event.GameName))
+	}
+	if !event.StartedAt.IsZero() {
+		message += fmt.Sprintf("⏱️ Streamed for %s\n", formatDuration(event.EndedAt.Sub(event.StartedAt)))
+	}
+
+	_, err := notifier.app.sendHTML(tgbotapi.NewMessage(target.ChatID, message))
+	return err
+}
 
-	message += fmt.Sprintf("🔗 https://twitch.tv/%s", streamer.Username)
+// Update tells the chat that a live stream switched category or changed its
+// title.
+func (notifier *TelegramNotifier) Update(target NotificationTarget, event *NotificationEvent) error {
+	var message string
+	if event.GameChanged {
+		message += fmt.Sprintf("🎮 %s changed category to %s\n", htmlChannelLink(event.streamer()), htmlItalic(event.GameName))
+	}
+	if event.TitleChanged {
+		message += fmt.Sprintf("📺 %s has a new title: %s\n", htmlChannelLink(event.streamer()), escapeHTML(event.Title))
+	}
 
-	msg := tgbotapi.NewMessage(app.config.TelegramChatID, message)
-	_, err := app.bot.Send(msg)
+	msg := tgbotapi.NewMessage(target.ChatID, strings.TrimSuffix(message, "\n"))
+	msg.ReplyMarkup = watchKeyboard(event.Username)
+	_, err := notifier.app.sendHTML(msg)
 	return err
 }
 
+func watchKeyboard(username string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL("📺 Watch", "https://twitch.tv/"+username)),
+	)
+}
+
+// sendLiveMessage sends the notification as a thumbnail photo with the text as
+// caption, falling back to a plain text message if the photo can't be sent.
+func (app *App) sendLiveMessage(chatID int64, text, thumbnailURL, username string) (LiveMessage, error) {
+	keyboard := watchKeyboard(username)
+
+	if thumbnailURL != "" {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(thumbnailURL))
+		photo.Caption = text
+		photo.ParseMode = tgbotapi.ModeHTML
+		photo.ReplyMarkup = keyboard
+		sent, err := app.telegram.send(chatID, photo)
+		if err == nil {
+			return LiveMessage{ChatID: chatID, MessageID: sent.MessageID, IsPhoto: true}, nil
+		}
+		log.Printf("Error sending photo notification to chat %d, falling back to text: %v", chatID, err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	sent, err := app.sendHTML(msg)
+	if err != nil {
+		return LiveMessage{}, err
+	}
+	return LiveMessage{ChatID: chatID, MessageID: sent.MessageID}, nil
+}
+
+// sendHTML sends a message in HTML parse mode, retrying as plain text if
+// Telegram rejects the markup.
+func (app *App) sendHTML(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+
+	sent, err := app.telegram.send(msg.ChatID, msg)
+	if isParseError(err) {
+		log.Printf("Telegram rejected HTML for chat %d, resending as plain text: %v", msg.ChatID, err)
+		msg.Text = stripHTML(msg.Text)
+		msg.ParseMode = ""
+		sent, err = app.telegram.send(msg.ChatID, msg)
+	}
+	return sent, err
+}
+
+// editLiveMessages queues the edits of a session's live messages. It is
+// called with statusMutex held, so the edits are only waited for in the
+// background and polling isn't held up by Telegram rate limits.
+func (app *App) editLiveMessages(streamer *Streamer, session *StreamSession) {
+	event := newNotificationEvent(streamer, session)
+	for _, message := range session.Messages {
+		app.queueEdit(message, app.renderLiveText(message.ChatID, event), streamer.Username, "live")
+	}
+}
+
+func (app *App) editEndedMessages(streamer *Streamer, session *StreamSession, endedAt time.Time) {
+	text := formatEndedText(streamer, session, endedAt)
+	for _, message := range session.Messages {
+		app.queueEdit(message, text, streamer.Username, "ended")
+	}
+}
+
+// queueEdit queues the edit of a live message and logs its outcome once
+// delivered. Edits of a chat are sent in the order they were queued; a
+// message Telegram rejects as bad HTML is edited again as plain text.
+func (app *App) queueEdit(message LiveMessage, text, username, kind string) {
+	job, err := app.telegram.enqueue(message.ChatID, newMessageEdit(message, text, username, tgbotapi.ModeHTML))
+	if err != nil {
+		log.Printf("Error queueing %s message edit for %s in chat %d: %v", kind, username, message.ChatID, err)
+		return
+	}
+
+	go func() {
+		_, err := app.telegram.wait(job)
+		if isParseError(err) {
+			_, err = app.telegram.send(message.ChatID, newMessageEdit(message, stripHTML(text), username, ""))
+		}
+		if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			log.Printf("Error editing %s message for %s in chat %d: %v", kind, username, message.ChatID, err)
+		}
+	}()
+}
+
+func newMessageEdit(message LiveMessage, text, username, parseMode string) tgbotapi.Chattable {
+	keyboard := watchKeyboard(username)
+	if message.IsPhoto {
+		caption := tgbotapi.NewEditMessageCaption(message.ChatID, message.MessageID, text)
+		caption.ParseMode = parseMode
+		caption.ReplyMarkup = &keyboard
+		return caption
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(message.ChatID, message.MessageID, text, keyboard)
+	edit.ParseMode = parseMode
+	edit.DisableWebPagePreview = true
+	return edit
+}
+
+// formatThumbnailURL fills in the Helix thumbnail size placeholders and adds a
+// cache-busting parameter so Telegram doesn't serve a stale preview.
+func formatThumbnailURL(template string) string {
+	if template == "" {
+		return ""
+	}
+
+	thumbnailURL := strings.NewReplacer(
+		"{width}", strconv.Itoa(ThumbnailWidth),
+		"{height}", strconv.Itoa(ThumbnailHeight),
+	).Replace(template)
+
+	return fmt.Sprintf("%s?t=%d", thumbnailURL, time.Now().Unix())
+}
+
+// handleTelegramUpdates long-polls getUpdates and dispatches commands. Each
+// successful call is recorded, so readiness notices a loop that stopped or
+// keeps failing.
 func (app *App) handleTelegramUpdates() {
 	log.Println("Starting Telegram updates handler")
 	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	u.Timeout = int(TelegramUpdatesTimeout / time.Second)
 
-	updates := app.bot.GetUpdatesChan(u)
-
-	for update := range updates {
+	for {
 		select {
 		case <-app.ctx.Done():
 			log.Println("Telegram updates handler stopping")
@@ -38,16 +201,35 @@ func (app *App) handleTelegramUpdates() {
 		default:
 		}
 
-		if update.Message == nil {
+		updates, err := app.bot.GetUpdates(u)
+		if err != nil {
+			log.Printf("Error getting Telegram updates, retrying in %v: %v", TelegramUpdatesRetryDelay, err)
+			select {
+			case <-app.ctx.Done():
+				log.Println("Telegram updates handler stopping")
+				return
+			case <-time.After(TelegramUpdatesRetryDelay):
+			}
 			continue
 		}
+		app.metrics.lastTelegramUpdate.Store(time.Now().Unix())
 
-		if update.Message.Chat.ID != app.config.TelegramChatID {
-			log.Printf("Ignoring message from unauthorized chat: %d", update.Message.Chat.ID)
-			continue
-		}
+		for _, update := range updates {
+			if update.UpdateID >= u.Offset {
+				u.Offset = update.UpdateID + 1
+			}
 
-		go app.handleTelegramCommand(update.Message)
+			if update.Message == nil {
+				continue
+			}
+
+			if !app.isChatAllowed(update.Message.Chat.ID) {
+				log.Printf("Ignoring message from unauthorized chat: %d", update.Message.Chat.ID)
+				continue
+			}
+
+			go app.handleTelegramCommand(update.Message)
+		}
 	}
 }
 
@@ -56,7 +238,7 @@ func (app *App) handleTelegramCommand(message *tgbotapi.Message) {
 		if r := recover(); r != nil {
 			log.Printf("Panic in command handler: %v", r)
 			msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Internal error processing command. Please try again.")
-			if _, err := app.bot.Send(msg); err != nil {
+			if _, err := app.telegram.send(message.Chat.ID, msg); err != nil {
 				log.Printf("Error sending panic recovery message: %v", err)
 			}
 		}
@@ -64,54 +246,69 @@ func (app *App) handleTelegramCommand(message *tgbotapi.Message) {
 
 	command := message.Command()
 	args := message.CommandArguments()
+	chatID := message.Chat.ID
 
 	var responseText string
 	switch command {
 	case "add":
-		responseText = app.handleAddCommand(args)
+		responseText = app.handleAddCommand(chatID, args)
 	case "remove", "delete":
-		responseText = app.handleRemoveCommand(args)
+		responseText = app.handleRemoveCommand(chatID, args)
 	case "list":
-		responseText = app.handleListCommand()
+		responseText = app.handleListCommand(chatID)
 	case "check":
-		responseText = app.handleCheckCommand()
+		responseText = app.handleCheckCommand(chatID)
+	case "history":
+		responseText = app.handleHistoryCommand(chatID, args)
+	case "template":
+		responseText = app.handleTemplateCommand(chatID, args)
+	case "offline":
+		responseText = app.handleToggleCommand(offlineToggle, chatID, args)
+	case "updates":
+		responseText = app.handleToggleCommand(updatesToggle, chatID, args)
+	case "targets":
+		responseText = app.handleTargetsCommand(chatID, args)
 	case "help":
 		responseText = app.getHelpText()
 	default:
 		if command != "" {
 			responseText = "Unknown command. Use /help to see available commands."
+			command = "unknown"
 		}
 	}
+	if command != "" {
+		app.metrics.commands.inc(command)
+	}
 
 	if responseText != "" {
 		msg := tgbotapi.NewMessage(message.Chat.ID, responseText)
-		if _, err := app.bot.Send(msg); err != nil {
+		if _, err := app.sendHTML(msg); err != nil {
 			log.Printf("Error sending Telegram message: %v", err)
 		}
 	}
 }
 
-func (app *App) handleAddCommand(args string) string {
+func (app *App) handleAddCommand(chatID int64, args string) string {
 	username, err := validateUsernameArg(args)
 	if err != nil {
-		return err.Error()
+		return escapeHTML(err.Error())
 	}
 
 	if existingStreamer := app.findStreamerByUsername(username); existingStreamer != nil {
-		return fmt.Sprintf("⚠️ %s is already in the notification list", existingStreamer.DisplayName)
+		return app.subscribeChat(chatID, existingStreamer)
 	}
 
 	streamer, err := app.getTwitchUser(username)
 	if err != nil {
 		log.Printf("Error getting Twitch user %s: %v", username, err)
-		return fmt.Sprintf("❌ Error: Could not find Twitch user '%s'. Please check the username and try again.", username)
+		return fmt.Sprintf("❌ Error: Could not find Twitch user '%s'. Please check the username and try again.", escapeHTML(username))
 	}
 
-	streamers := app.streamerManager.getStreamers()
-	for _, s := range streamers {
-		if s.UserID == streamer.UserID {
-			return fmt.Sprintf("⚠️ %s is already in the notification list (same as %s)", streamer.DisplayName, s.DisplayName)
+	if existingStreamer := app.findStreamerByUserID(streamer.UserID); existingStreamer != nil {
+		if app.streamerManager.isSubscribed(existingStreamer.Username, chatID) {
+			return fmt.Sprintf("⚠️ %s is already in the notification list (same as %s)", htmlBold(streamer.DisplayName), htmlBold(existingStreamer.DisplayName))
 		}
+		return app.subscribeChat(chatID, existingStreamer)
 	}
 
 	streamInfo, err := app.getStreamInfo(streamer.UserID)
@@ -119,45 +316,64 @@ func (app *App) handleAddCommand(args string) string {
 		log.Printf("Error checking stream status for %s: %v", streamer.Username, err)
 	}
 	streamer.IsLive = streamInfo != nil && len(streamInfo.Data) > 0
+	streamer.Subscribers = []int64{chatID}
 
 	if err := app.streamerManager.addStreamer(streamer); err != nil {
 		log.Printf("Error adding streamer %s: %v", username, err)
-		return fmt.Sprintf("❌ Error adding streamer: %v", err)
+		return fmt.Sprintf("❌ Error adding streamer: %s", escapeHTML(err.Error()))
 	}
+	go app.subscribeStreamerEvents(streamer)
 
-	return fmt.Sprintf("✅ Added %s (%s) to notifications", streamer.DisplayName, streamer.Username)
+	return fmt.Sprintf("✅ Added %s (%s) to notifications", htmlChannelLink(streamer), escapeHTML(streamer.Username))
 }
 
-func (app *App) handleRemoveCommand(args string) string {
+func (app *App) subscribeChat(chatID int64, streamer *Streamer) string {
+	if app.streamerManager.isSubscribed(streamer.Username, chatID) {
+		return fmt.Sprintf("⚠️ %s is already in the notification list", htmlBold(streamer.DisplayName))
+	}
+
+	if err := app.streamerManager.addSubscriber(streamer.Username, chatID); err != nil {
+		log.Printf("Error subscribing chat %d to %s: %v", chatID, streamer.Username, err)
+		return fmt.Sprintf("❌ Error adding streamer: %s", escapeHTML(err.Error()))
+	}
+
+	return fmt.Sprintf("✅ Added %s (%s) to notifications", htmlChannelLink(streamer), escapeHTML(streamer.Username))
+}
+
+func (app *App) handleRemoveCommand(chatID int64, args string) string {
 	username, err := validateUsernameArg(args)
 	if err != nil {
-		return strings.ReplaceAll(err.Error(), "/add", "/remove")
+		return escapeHTML(strings.ReplaceAll(err.Error(), "/add", "/remove"))
 	}
 
 	removedStreamer := app.findStreamerByUsername(username)
-	if removedStreamer == nil {
-		return fmt.Sprintf("❌ %s is not in the notification list", username)
+	if removedStreamer == nil || !app.streamerManager.isSubscribed(username, chatID) {
+		return fmt.Sprintf("❌ %s is not in the notification list", escapeHTML(username))
 	}
 
-	if err := app.streamerManager.removeStreamer(username); err != nil {
+	dropped, err := app.streamerManager.removeSubscriber(username, chatID)
+	if err != nil {
 		log.Printf("Error removing streamer %s: %v", username, err)
-		return fmt.Sprintf("❌ Error removing streamer: %v", err)
+		return fmt.Sprintf("❌ Error removing streamer: %s", escapeHTML(err.Error()))
+	}
+	if dropped {
+		go app.unsubscribeStreamerEvents(removedStreamer.UserID)
 	}
 
-	return fmt.Sprintf("✅ Removed %s from notifications", removedStreamer.DisplayName)
+	return fmt.Sprintf("✅ Removed %s from notifications", htmlBold(removedStreamer.DisplayName))
 }
 
-func (app *App) handleListCommand() string {
-	streamers := app.streamerManager.getStreamers()
+func (app *App) handleListCommand(chatID int64) string {
+	streamers := app.streamerManager.getStreamersForChat(chatID)
 	if len(streamers) == 0 {
-		return "📋 No streamers in the notification list.\n\nUse /add <username> to add streamers!"
+		return "📋 No streamers in the notification list.\n\nUse /add &lt;username&gt; to add streamers!"
 	}
 
-	responseText := "📋 Current streamers:\n\n"
+	responseText := "📋 <b>Current streamers:</b>\n\n"
 
 	for _, streamer := range streamers {
 		status := map[bool]string{true: "🔴", false: "⚫"}[streamer.IsLive]
-		responseText += fmt.Sprintf("%s %s (%s)\n", status, streamer.DisplayName, streamer.Username)
+		responseText += fmt.Sprintf("%s %s (%s)\n", status, htmlChannelLink(streamer), escapeHTML(streamer.Username))
 	}
 
 	responseText += fmt.Sprintf("\n📊 Total: %d streamers", len(streamers))
@@ -165,19 +381,19 @@ func (app *App) handleListCommand() string {
 	return responseText
 }
 
-func (app *App) handleCheckCommand() string {
-	streamers := app.streamerManager.getStreamers()
+func (app *App) handleCheckCommand(chatID int64) string {
+	streamers := app.streamerManager.getStreamersForChat(chatID)
 	if len(streamers) == 0 {
-		return "📋 No streamers to check.\n\nUse /add <username> to add streamers!"
+		return "📋 No streamers to check.\n\nUse /add &lt;username&gt; to add streamers!"
 	}
 
-	responseText := "🔍 Live Status Check:\n\n"
+	responseText := "🔍 <b>Live Status Check:</b>\n\n"
 
 	for _, streamer := range streamers {
 		streamInfo, err := app.getStreamInfo(streamer.UserID)
 		if err != nil {
 			log.Printf("Error checking stream for %s: %v", streamer.Username, err)
-			responseText += fmt.Sprintf("❌ %s - Error checking status\n", streamer.DisplayName)
+			responseText += fmt.Sprintf("❌ %s - Error checking status\n", htmlBold(streamer.DisplayName))
 			continue
 		}
 
@@ -188,12 +404,12 @@ func (app *App) handleCheckCommand() string {
 		}
 
 		if streamData != nil {
-			responseText += fmt.Sprintf("🔴 %s is LIVE!\n", streamer.DisplayName)
-			responseText += fmt.Sprintf("   📺 %s\n", streamData.Title)
-			responseText += fmt.Sprintf("   🎮 %s\n", streamData.GameName)
+			responseText += fmt.Sprintf("🔴 %s is LIVE!\n", htmlChannelLink(streamer))
+			responseText += fmt.Sprintf("   📺 %s\n", escapeHTML(streamData.Title))
+			responseText += fmt.Sprintf("   🎮 %s\n", htmlItalic(streamData.GameName))
 			responseText += fmt.Sprintf("   👥 %d viewers\n\n", streamData.ViewerCount)
 		} else {
-			responseText += fmt.Sprintf("⚫ %s is offline\n", streamer.DisplayName)
+			responseText += fmt.Sprintf("⚫ %s is offline\n", htmlBold(streamer.DisplayName))
 		}
 
 		if err := app.checkAndUpdateStreamerStatus(streamer, streamData, false); err != nil {
@@ -205,26 +421,154 @@ func (app *App) handleCheckCommand() string {
 	return responseText
 }
 
-func (app *App) getHelpText() string {
-	return fmt.Sprintf(`🤖 Twitch Notification Bot Commands:
+// handleHistoryCommand shows the last sessions of a streamer:
+// /history <username> [n]
+func (app *App) handleHistoryCommand(chatID int64, args string) string {
+	username, rest := splitFirstArg(args)
+	username = strings.ToLower(username)
+	if username == "" {
+		return "Usage: /history &lt;username&gt; [n]"
+	}
+	if !app.streamerManager.isSubscribed(username, chatID) {
+		return fmt.Sprintf("❌ %s is not in the notification list", escapeHTML(username))
+	}
 
-/add <username> - Add a Twitch streamer to notifications
-/remove <username> - Remove a streamer from notifications  
-/list - Show all tracked streamers with live status
-/check - Check current live status and update internal state
-/help - Show this help message
+	limit := DefaultHistoryLength
+	if countArg := strings.TrimSpace(rest); countArg != "" {
+		count, err := strconv.Atoi(countArg)
+		if err != nil || count < 1 {
+			return "❌ The number of sessions must be a positive number"
+		}
+		limit = min(count, MaxHistoryLength)
+	}
 
-🔄 Polling System:
+	records, err := app.streamerManager.getSessionHistory(username, limit)
+	if err != nil {
+		log.Printf("Error loading history of %s: %v", username, err)
+		return "❌ Error loading stream history"
+	}
+	if len(records) == 0 {
+		return fmt.Sprintf("📜 No recorded streams for %s yet", htmlBold(username))
+	}
+
+	responseText := fmt.Sprintf("📜 <b>Last %d streams of %s:</b>\n\n", len(records), escapeHTML(records[0].DisplayName))
+	for _, record := range records {
+		responseText += fmt.Sprintf("📅 %s - ⏱️ %s\n", htmlBold(record.StartedAt.UTC().Format("2006-01-02 15:04 UTC")), formatDuration(record.EndedAt.Sub(record.StartedAt)))
+		if len(record.Titles) > 0 {
+			responseText += fmt.Sprintf("   📺 %s\n", escapeHTML(record.Titles[len(record.Titles)-1]))
+		}
+		if len(record.Games) > 0 {
+			responseText += fmt.Sprintf("   🎮 %s\n", htmlItalic(strings.Join(record.Games, ", ")))
+		}
+		responseText += fmt.Sprintf("   👥 %d peak, %d average viewers\n\n", record.PeakViewers, record.AverageViewers)
+	}
+	return strings.TrimRight(responseText, "\n")
+}
+
+// handleTemplateCommand manages notification templates:
+// /template [streamer <username>] <show|set|preview|reset> [template]
+func (app *App) handleTemplateCommand(chatID int64, args string) string {
+	action, rest := splitFirstArg(args)
+
+	username := ""
+	if action == "streamer" {
+		username, rest = splitFirstArg(rest)
+		username = strings.ToLower(username)
+		if username == "" {
+			return htmlPre(templateUsage)
+		}
+		if !app.streamerManager.isSubscribed(username, chatID) {
+			return fmt.Sprintf("❌ %s is not in the notification list", escapeHTML(username))
+		}
+		action, rest = splitFirstArg(rest)
+	}
+
+	switch action {
+	case "", "show":
+		text, source := app.resolveTemplate(chatID, username)
+		return fmt.Sprintf("📝 Current template (%s):\n\n%s", source, htmlPre(text))
+	case "preview", "set":
+		text := strings.TrimSpace(rest)
+		if text == "" {
+			return htmlPre(templateUsage)
+		}
+
+		rendered, err := validateNotificationTemplate(text)
+		if err != nil {
+			return fmt.Sprintf("❌ Invalid template: %s", escapeHTML(err.Error()))
+		}
+		if action == "preview" {
+			return fmt.Sprintf("👀 Template preview:\n\n%s", rendered)
+		}
+
+		if err := app.streamerManager.updateChatSettings(chatID, func(chat *ChatSettings) {
+			if username == "" {
+				chat.Template = text
+			} else {
+				chat.streamerPrefs(username).Template = text
+			}
+		}); err != nil {
+			return fmt.Sprintf("❌ Error saving template: %s", escapeHTML(err.Error()))
+		}
+		return fmt.Sprintf("✅ Template saved. Preview:\n\n%s", rendered)
+	case "reset":
+		if err := app.streamerManager.updateChatSettings(chatID, func(chat *ChatSettings) {
+			if username == "" {
+				chat.Template = ""
+				return
+			}
+			chat.streamerPrefs(username).Template = ""
+			chat.pruneStreamerPrefs(username)
+		}); err != nil {
+			return fmt.Sprintf("❌ Error resetting template: %s", escapeHTML(err.Error()))
+		}
+		return "✅ Template reset"
+	default:
+		return htmlPre(templateUsage)
+	}
+}
+
+const templateUsage = `usage: /template [streamer <username>] <show|set|preview|reset> [template]
+
+Fields: {{.DisplayName}} {{.Username}} {{.Title}} {{.Game}} {{.Viewers}} {{.URL}} {{.StartedAt}}
+Templates use Telegram HTML (<b>, <i>, <a href="...">); field values are escaped automatically.`
+
+func (app *App) getHelpText() string {
+	monitoring := fmt.Sprintf(`🔄 <b>Polling System:</b>
 • All streamers monitored via polling (~%ds delay)
 • Reliable notification delivery
-• No setup required
+• No setup required`, int(app.config.PollingInterval.Seconds()))
+	if app.eventSub != nil {
+		monitoring = fmt.Sprintf(`⚡ <b>EventSub System:</b>
+• Near-instant notifications via Twitch EventSub
+• Polling fallback (~%ds delay) while the socket is down`, int(app.config.PollingInterval.Seconds()))
+	}
 
-Examples:
-/add ninja              # Add ninja to notifications
-/add shroud            # Add shroud to notifications  
+	return fmt.Sprintf(`🤖 <b>Twitch Notification Bot Commands:</b>
+
+/add &lt;username&gt; - Add a Twitch streamer to notifications
+/remove &lt;username&gt; - Remove a streamer from notifications
+/list - Show this chat's tracked streamers with live status
+/check - Check this chat's streamers and update internal state
+/history &lt;username&gt; [n] - Show the last streams of a streamer
+/template - Show or customize this chat's notification template
+/offline [on|off|reset] [username] - Toggle stream-ended notifications
+/updates [on|off|reset] [username] - Toggle title/category change notifications
+/targets &lt;username&gt; - Show or change where notifications for a streamer go (Telegram, Discord, Slack, Matrix, webhooks)
+/help - Show this help message
+
+%s
+
+<b>Examples:</b>
+%s`,
+		monitoring, htmlPre(`/add ninja              # Add ninja to notifications
+/add shroud            # Add shroud to notifications
 /list                  # View all streamers
-/remove ninja          # Remove ninja`,
-		int(app.config.PollingInterval.Seconds()))
+/remove ninja          # Remove ninja`))
+}
+
+func (app *App) isChatAllowed(chatID int64) bool {
+	return app.config.AllowAllChats || app.config.AllowedChatIDs[chatID]
 }
 
 func (app *App) findStreamerByUsername(username string) *Streamer {
@@ -237,6 +581,26 @@ func (app *App) findStreamerByUsername(username string) *Streamer {
 	return nil
 }
 
+func (app *App) findStreamerByUserID(userID string) *Streamer {
+	streamers := app.streamerManager.getStreamers()
+	for _, s := range streamers {
+		if s.UserID == userID {
+			return s
+		}
+	}
+	return nil
+}
+
+// splitFirstArg splits off the first whitespace-separated argument, keeping
+// the formatting of the remainder intact.
+func splitFirstArg(args string) (string, string) {
+	args = strings.TrimLeft(args, " \t\n")
+	if index := strings.IndexAny(args, " \t\n"); index >= 0 {
+		return args[:index], args[index+1:]
+	}
+	return args, ""
+}
+
 func validateUsernameArg(args string) (string, error) {
 	if args == "" {
 		return "", fmt.Errorf("usage: /add <twitch_username>")
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newTelegramQueue(ctx context.Context, bot *tgbotapi.BotAPI) *TelegramQueue {
+	return &TelegramQueue{
+		bot:    bot,
+		ctx:    ctx,
+		global: newRateLimiter("Telegram", TelegramGlobalRate, time.Second),
+		chats:  make(map[int64]chan *telegramJob),
+	}
+}
+
+// send queues a request for a chat and waits until it was delivered or
+// ultimately failed. Requests to the same chat are sent in order.
+func (q *TelegramQueue) send(chatID int64, message tgbotapi.Chattable) (tgbotapi.Message, error) {
+	job, err := q.enqueue(chatID, message)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	return q.wait(job)
+}
+
+// enqueue queues a request for a chat without waiting for its delivery, so
+// callers holding a lock keep the order of their requests without blocking on
+// rate limits. The result is collected with wait.
+func (q *TelegramQueue) enqueue(chatID int64, message tgbotapi.Chattable) (*telegramJob, error) {
+	job := &telegramJob{
+		chatID:  chatID,
+		message: message,
+		result:  make(chan telegramResult, 1),
+	}
+
+	select {
+	case q.chatQueue(chatID) <- job:
+		return job, nil
+	case <-q.ctx.Done():
+		return nil, q.ctx.Err()
+	}
+}
+
+// wait returns the result of a queued request once it was delivered or
+// ultimately failed.
+func (q *TelegramQueue) wait(job *telegramJob) (tgbotapi.Message, error) {
+	select {
+	case result := <-job.result:
+		return result.message, result.err
+	case <-q.ctx.Done():
+		return tgbotapi.Message{}, q.ctx.Err()
+	}
+}
+
+// chatQueue returns the queue of a chat, starting its worker on first use.
+func (q *TelegramQueue) chatQueue(chatID int64) chan *telegramJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, exists := q.chats[chatID]
+	if !exists {
+		queue = make(chan *telegramJob, TelegramQueueSize)
+		q.chats[chatID] = queue
+		go q.runChatWorker(chatID, queue)
+	}
+	return queue
+}
+
+// runChatWorker delivers the requests of one chat, at most one per
+// TelegramPrivateInterval in private chats and TelegramGroupInterval in
+// groups, as Telegram recommends.
+func (q *TelegramQueue) runChatWorker(chatID int64, queue chan *telegramJob) {
+	interval := TelegramPrivateInterval
+	if chatID < 0 {
+		interval = TelegramGroupInterval
+	}
+	limiter := newRateLimiter(fmt.Sprintf("Telegram chat %d", chatID), 1, interval)
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case job := <-queue:
+			message, err := q.deliver(job, limiter)
+			job.result <- telegramResult{message: message, err: err}
+		}
+	}
+}
+
+func (q *TelegramQueue) deliver(job *telegramJob, limiter *RateLimiter) (tgbotapi.Message, error) {
+	for attempt := 1; ; attempt++ {
+		if err := limiter.wait(q.ctx); err != nil {
+			return tgbotapi.Message{}, err
+		}
+		if err := q.global.wait(q.ctx); err != nil {
+			return tgbotapi.Message{}, err
+		}
+
+		message, err := q.bot.Send(job.message)
+		if err == nil {
+			return message, nil
+		}
+
+		retryAfter, retryable := telegramRetryDelay(err, attempt)
+		if !retryable || attempt >= TelegramMaxAttempts {
+			return message, err
+		}
+
+		log.Printf("Telegram request to chat %d failed (attempt %d/%d), retrying in %v: %v", job.chatID, attempt, TelegramMaxAttempts, retryAfter.Round(time.Millisecond), err)
+		now := time.Now()
+		limiter.blockUntil(now.Add(retryAfter), now)
+	}
+}
+
+// telegramRetryDelay reports whether a failed request is worth retrying and
+// after how long: flood control errors carry retry_after, server and network
+// errors back off exponentially. Other API errors (bad markup, blocked bot,
+// unmodified edits) are returned to the caller as is.
+func telegramRetryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		}
+		if apiErr.Code < 500 {
+			return 0, false
+		}
+	}
+	return retryDelay(attempt, TelegramRetryBaseDelay, TelegramRetryMaxDelay), true
+}
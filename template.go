@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultNotificationTemplate renders Telegram HTML. Fields are escaped before
+// rendering, so templates only need to care about their own markup.
+const DefaultNotificationTemplate = `🔴 <a href="{{.URL}}"><b>{{.DisplayName}}</b></a> is now live!
+
+{{if .Title}}📺 {{.Title}}
+🎮 <i>{{.Game}}</i>
+👥 {{.Viewers}} viewers
+
+{{end}}🔗 {{.URL}}`
+
+const maxTemplateOutputLength = 1024
+
+func parseNotificationTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Option("missingkey=error").Parse(text)
+}
+
+func executeTemplate(tmpl *template.Template, data *NotificationData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validateNotificationTemplate parses the template and renders it against
+// sample data so unknown fields are rejected before the template is saved.
+func validateNotificationTemplate(text string) (string, error) {
+	tmpl, err := parseNotificationTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	rendered, err := executeTemplate(tmpl, sampleNotificationData("streamer", "Streamer"))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(rendered) == "" {
+		return "", fmt.Errorf("template renders an empty message")
+	}
+	if len(rendered) > maxTemplateOutputLength {
+		return "", fmt.Errorf("template renders %d characters, the limit is %d", len(rendered), maxTemplateOutputLength)
+	}
+	if err := validateTelegramHTML(rendered); err != nil {
+		return "", err
+	}
+	return rendered, nil
+}
+
+func sampleNotificationData(username, displayName string) *NotificationData {
+	return &NotificationData{
+		DisplayName: escapeHTML(displayName),
+		Username:    escapeHTML(username),
+		Title:       escapeHTML("Sample <stream> title & *more* [tags]"),
+		Game:        "Just Chatting",
+		Viewers:     1234,
+		URL:         escapeHTML("https://twitch.tv/" + username),
+		StartedAt:   time.Now().Add(-time.Hour),
+	}
+}
+
+func newNotificationData(event *NotificationEvent) *NotificationData {
+	return &NotificationData{
+		DisplayName: escapeHTML(event.DisplayName),
+		Username:    escapeHTML(event.Username),
+		Title:       escapeHTML(event.Title),
+		Game:        escapeHTML(event.GameName),
+		Viewers:     event.ViewerCount,
+		URL:         escapeHTML(event.channelURL()),
+		StartedAt:   event.StartedAt,
+	}
+}
+
+// resolveTemplate returns the template text for a chat and streamer along with
+// where it came from, in order of precedence: streamer override, chat
+// template, global template.
+func (app *App) resolveTemplate(chatID int64, username string) (string, string) {
+	chat := app.streamerManager.getChatSettings(chatID)
+	if prefs, exists := chat.Streamers[username]; exists && prefs.Template != "" {
+		return prefs.Template, "streamer override"
+	}
+	if chat.Template != "" {
+		return chat.Template, "chat"
+	}
+	if app.config.NotificationTemplate != "" {
+		return app.config.NotificationTemplate, "global"
+	}
+	return DefaultNotificationTemplate, "default"
+}
+
+func (app *App) renderLiveText(chatID int64, event *NotificationEvent) string {
+	text, source := app.resolveTemplate(chatID, event.Username)
+	data := newNotificationData(event)
+
+	tmpl, err := parseNotificationTemplate(text)
+	if err == nil {
+		var rendered string
+		if rendered, err = executeTemplate(tmpl, data); err == nil {
+			return rendered
+		}
+	}
+
+	log.Printf("Error rendering %s template for chat %d, using default: %v", source, chatID, err)
+	tmpl = template.Must(parseNotificationTemplate(DefaultNotificationTemplate))
+	rendered, _ := executeTemplate(tmpl, data)
+	return rendered
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var offlineToggle = &NotificationToggle{
+	Name:          "Offline notifications",
+	Command:       "offline",
+	Default:       func(config *Config) bool { return config.OfflineNotifications },
+	ChatValue:     func(chat *ChatSettings) **bool { return &chat.OfflineNotifications },
+	StreamerValue: func(prefs *StreamerPrefs) **bool { return &prefs.OfflineNotifications },
+}
+
+var updatesToggle = &NotificationToggle{
+	Name:          "Title/category change notifications",
+	Command:       "updates",
+	Default:       func(config *Config) bool { return config.UpdateNotifications },
+	ChatValue:     func(chat *ChatSettings) **bool { return &chat.UpdateNotifications },
+	StreamerValue: func(prefs *StreamerPrefs) **bool { return &prefs.UpdateNotifications },
+}
+
+// toggleEnabled resolves a notification toggle in order of precedence:
+// streamer override, chat setting, global default.
+func (app *App) toggleEnabled(toggle *NotificationToggle, chatID int64, username string) bool {
+	chat := app.streamerManager.getChatSettings(chatID)
+	if prefs, exists := chat.Streamers[username]; exists && username != "" {
+		if value := *toggle.StreamerValue(prefs); value != nil {
+			return *value
+		}
+	}
+	if value := *toggle.ChatValue(&chat); value != nil {
+		return *value
+	}
+	return toggle.Default(&app.config)
+}
+
+// handleToggleCommand shows or changes a toggle for the chat or, when a
+// username is given, for one streamer: /<command> [on|off|reset] [username]
+func (app *App) handleToggleCommand(toggle *NotificationToggle, chatID int64, args string) string {
+	action, rest := splitFirstArg(args)
+	username := strings.ToLower(strings.TrimSpace(rest))
+
+	if username != "" && !app.streamerManager.isSubscribed(username, chatID) {
+		return fmt.Sprintf("❌ %s is not in the notification list", escapeHTML(username))
+	}
+
+	var value *bool
+	switch action {
+	case "":
+		return fmt.Sprintf("🔔 %s %s %s", toggle.Name, toggleScope(username), htmlBold(app.toggleStatus(toggle, chatID, username)))
+	case "on", "off":
+		enabled := action == "on"
+		value = &enabled
+	case "reset":
+	default:
+		return escapeHTML(fmt.Sprintf("usage: /%s [on|off|reset] [username]", toggle.Command))
+	}
+
+	if err := app.streamerManager.updateChatSettings(chatID, func(chat *ChatSettings) {
+		if username == "" {
+			*toggle.ChatValue(chat) = value
+			return
+		}
+		*toggle.StreamerValue(chat.streamerPrefs(username)) = value
+		chat.pruneStreamerPrefs(username)
+	}); err != nil {
+		return fmt.Sprintf("❌ Error saving setting: %s", escapeHTML(err.Error()))
+	}
+
+	return fmt.Sprintf("✅ %s %s now %s", toggle.Name, toggleScope(username), htmlBold(app.toggleStatus(toggle, chatID, username)))
+}
+
+func (app *App) toggleStatus(toggle *NotificationToggle, chatID int64, username string) string {
+	if app.toggleEnabled(toggle, chatID, username) {
+		return "on"
+	}
+	return "off"
+}
+
+func toggleScope(username string) string {
+	if username == "" {
+		return "for this chat are"
+	}
+	return fmt.Sprintf("for %s are", escapeHTML(username))
+}
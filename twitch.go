@@ -1,22 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// getTwitchToken makes sure a valid app access token is cached, refreshing
+// it if needed. Concurrent callers wait for a single refresh.
 func (app *App) getTwitchToken() error {
-	if time.Now().Before(app.tokenExpiry) {
+	app.tokenMutex.Lock()
+	defer app.tokenMutex.Unlock()
+
+	if app.twitchToken != "" && time.Now().Before(app.tokenExpiry) {
 		return nil
 	}
 
+	if err := app.refreshTwitchToken(); err != nil {
+		app.metrics.tokenRefreshes.inc("error")
+		return err
+	}
+	app.metrics.tokenRefreshes.inc("success")
+	return nil
+}
+
+func (app *App) currentTwitchToken() string {
+	app.tokenMutex.Lock()
+	defer app.tokenMutex.Unlock()
+	return app.twitchToken
+}
+
+// invalidateTwitchToken forces the next getTwitchToken to fetch a new token,
+// unless the rejected token was already replaced by another goroutine.
+func (app *App) invalidateTwitchToken(token string) {
+	app.tokenMutex.Lock()
+	defer app.tokenMutex.Unlock()
+
+	if app.twitchToken == token {
+		app.twitchToken = ""
+		app.tokenExpiry = time.Time{}
+	}
+}
+
+// refreshTwitchToken fetches a new app access token. The caller must hold
+// tokenMutex.
+func (app *App) refreshTwitchToken() error {
 	data := url.Values{}
 	data.Set("client_id", app.config.TwitchClientID)
 	data.Set("client_secret", app.config.TwitchClientSecret)
@@ -47,25 +84,127 @@ func (app *App) getTwitchToken() error {
 	return nil
 }
 
-func (app *App) makeHTTPRequest(req *http.Request) (*http.Response, error) {
-	return app.httpClient.Do(req)
+var errTwitchTokenInvalid = errors.New("token is invalid or expired")
+
+// startTokenValidator validates the app access token (and the user token, if
+// configured) every TokenValidationInterval as Twitch requires, so revoked
+// tokens are noticed even when no API call fails.
+func (app *App) startTokenValidator() {
+	go func() {
+		ticker := time.NewTicker(TokenValidationInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.ctx.Done():
+				return
+			case <-ticker.C:
+				app.validateTwitchTokens()
+			}
+		}
+	}()
 }
 
-func (app *App) callTwitchAPI(url string, target interface{}) error {
-	if err := app.getTwitchToken(); err != nil {
-		return err
+func (app *App) validateTwitchTokens() {
+	if token := app.currentTwitchToken(); token != "" {
+		_, err := app.validateTwitchToken(token)
+		if errors.Is(err, errTwitchTokenInvalid) {
+			log.Println("App access token is no longer valid, fetching a new one")
+			app.invalidateTwitchToken(token)
+			if err := app.getTwitchToken(); err != nil {
+				log.Printf("Error refreshing Twitch token: %v", err)
+			}
+		} else if err != nil {
+			log.Printf("Error validating app access token: %v", err)
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultHTTPTimeout)
-	defer cancel()
+	if app.config.TwitchUserToken != "" {
+		validation, err := app.validateTwitchToken(app.config.TwitchUserToken)
+		if err != nil {
+			log.Printf("Warning: TWITCH_USER_TOKEN failed validation, EventSub WebSocket subscriptions will fail: %v", err)
+		} else if expiresIn := time.Duration(validation.ExpiresIn) * time.Second; validation.ExpiresIn > 0 && expiresIn < 24*time.Hour {
+			log.Printf("Warning: TWITCH_USER_TOKEN expires in %v", expiresIn.Round(time.Minute))
+		}
+	}
+}
 
-	resp, err := app.makeTwitchAPIRequest(ctx, "GET", url, nil)
+// validateTwitchToken checks a token against id.twitch.tv/oauth2/validate.
+func (app *App) validateTwitchToken(token string) (*TwitchValidateResponse, error) {
+	req, err := http.NewRequestWithContext(app.ctx, "GET", "https://id.twitch.tv/oauth2/validate", nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	resp, err := app.makeHTTPRequest(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return app.decodeJSONResponse(resp, target)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errTwitchTokenInvalid
+	}
+
+	var validation TwitchValidateResponse
+	if err := app.decodeJSONResponse(resp, &validation); err != nil {
+		return nil, err
+	}
+	return &validation, nil
+}
+
+func (app *App) makeHTTPRequest(req *http.Request) (*http.Response, error) {
+	return app.httpClient.Do(req)
+}
+
+// callTwitchAPI sends a GET request with the app access token, retrying
+// transient failures with jittered exponential backoff. The final outcome is
+// fed to the circuit breaker.
+func (app *App) callTwitchAPI(url string, target interface{}) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = app.callTwitchAPIOnce(url, target)
+		if err == nil || !isTransientError(err) || attempt >= TwitchMaxRetries {
+			break
+		}
+
+		delay := retryDelay(attempt, TwitchRetryBaseDelay, TwitchRetryMaxDelay)
+		log.Printf("Twitch API request failed (attempt %d/%d), retrying in %v: %v", attempt, TwitchMaxRetries, delay.Round(time.Millisecond), err)
+		if !sleepContext(app.ctx, delay) {
+			break
+		}
+	}
+
+	app.recordTwitchResult(err)
+	return err
+}
+
+// callTwitchAPIOnce sends a GET request with the app access token. If Twitch
+// rejects the token with a 401 (e.g. it was revoked), a new token is fetched
+// and the request is retried once.
+func (app *App) callTwitchAPIOnce(url string, target interface{}) error {
+	for attempt := 1; ; attempt++ {
+		if err := app.getTwitchToken(); err != nil {
+			return err
+		}
+		token := app.currentTwitchToken()
+
+		resp, err := app.makeTwitchAPIRequestWithToken(app.ctx, "GET", url, token, nil)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 {
+			resp.Body.Close()
+			log.Println("Twitch rejected the app access token, fetching a new one and retrying")
+			app.invalidateTwitchToken(token)
+			continue
+		}
+
+		defer resp.Body.Close()
+		return app.decodeJSONResponse(resp, target)
+	}
 }
 
 func (app *App) getTwitchUser(username string) (*Streamer, error) {
@@ -100,25 +239,62 @@ func (app *App) getStreamInfo(userID string) (*TwitchStreamResponse, error) {
 	return &streamResp, nil
 }
 
-func (app *App) makeTwitchAPIRequest(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
-	if err != nil {
-		return nil, err
+// makeTwitchAPIRequestWithToken sends a Helix request once the rate limiter
+// of the token allows it. On HTTP 429 it waits for the bucket to reset and
+// retries; ctx only bounds the waiting, each attempt is bounded by the HTTP
+// client timeout.
+func (app *App) makeTwitchAPIRequestWithToken(ctx context.Context, method, url, token string, body []byte) (*http.Response, error) {
+	limiter := app.rateLimiter
+	if token != "" && token == app.config.TwitchUserToken {
+		limiter = app.userRateLimiter
 	}
 
-	req.Header.Set("Client-ID", app.config.TwitchClientID)
-	req.Header.Set("Authorization", "Bearer "+app.twitchToken)
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+	for attempt := 1; ; attempt++ {
+		if err := limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Client-ID", app.config.TwitchClientID)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := app.makeHTTPRequest(req)
+		if err != nil {
+			app.metrics.twitchAPIRequests.inc("error")
+			return nil, err
+		}
+		app.metrics.twitchAPIRequests.inc(strconv.Itoa(resp.StatusCode))
+		limiter.observe(resp.Header)
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= MaxRateLimitRetries {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		wait := limiter.throttle(resp.Header)
+		log.Printf("Twitch rate limit hit on %s %s, retrying in %v (attempt %d/%d)", method, req.URL.Path, wait.Round(time.Second), attempt, MaxRateLimitRetries)
 	}
+}
 
-	return app.makeHTTPRequest(req)
+func (err *APIStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", err.StatusCode, err.Body)
 }
 
 func (app *App) decodeJSONResponse(resp *http.Response, target interface{}) error {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return &APIStatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -130,27 +306,133 @@ func (app *App) decodeJSONResponse(resp *http.Response, target interface{}) erro
 }
 
 func (app *App) checkAndUpdateStreamerStatus(streamer *Streamer, streamData *TwitchStreamData, sendNotification bool) error {
+	app.statusMutex.Lock()
+	defer app.statusMutex.Unlock()
+
 	isCurrentlyLive := streamData != nil
 
 	if isCurrentlyLive && !streamer.IsLive {
 		log.Printf("Stream detected online: %s (%s)", streamer.DisplayName, streamer.Username)
+		return app.startStream(streamer, streamData, sendNotification)
+	}
 
-		if sendNotification {
-			streamResp := &TwitchStreamResponse{
-				Data: []TwitchStreamData{*streamData},
-			}
-			if err := app.sendNotification(streamer, streamResp); err != nil {
-				log.Printf("Error sending notification for %s: %v", streamer.Username, err)
-			}
+	if isCurrentlyLive && streamer.IsLive {
+		session := app.streamerManager.getSession(streamer.UserID)
+		if session != nil && session.isDifferentBroadcast(streamData) {
+			log.Printf("New broadcast detected: %s (%s)", streamer.DisplayName, streamer.Username)
+			outbox := app.endStream(streamer, session, sendNotification)
+			return app.startStream(streamer, streamData, sendNotification, outbox...)
 		}
-
-		return app.streamerManager.updateStreamerStatus(streamer.UserID, true)
+		return app.refreshLiveSession(streamer, streamData, sendNotification)
 	}
 
 	if !isCurrentlyLive && streamer.IsLive {
+		session := app.streamerManager.getSession(streamer.UserID)
+		if session != nil && !app.confirmOffline(session) {
+			log.Printf("Stream missing from check %d/%d: %s (%s)", session.MissedChecks, app.config.OfflineMissThreshold, streamer.DisplayName, streamer.Username)
+			return app.streamerManager.updateStreamerStatus(streamer.UserID, true, session)
+		}
+
 		log.Printf("Stream detected offline: %s (%s)", streamer.DisplayName, streamer.Username)
-		return app.streamerManager.updateStreamerStatus(streamer.UserID, false)
+		outbox := app.endStream(streamer, session, sendNotification)
+		return app.saveStatus(streamer.UserID, false, nil, outbox)
 	}
 
 	return nil
 }
+
+// confirmOffline records a check that didn't see the stream and reports
+// whether the streamer has now been missing for long enough to be considered
+// offline, so short blips don't end the session.
+func (app *App) confirmOffline(session *StreamSession) bool {
+	now := time.Now()
+	session.MissedChecks++
+	if session.FirstMissAt.IsZero() {
+		session.FirstMissAt = now
+	}
+
+	return session.MissedChecks >= app.config.OfflineMissThreshold &&
+		now.Sub(session.FirstMissAt) >= app.config.OfflineGracePeriod
+}
+
+// startStream saves the new session along with the live notifications
+// (after any outbox entries of the previous broadcast), which the outbox then
+// delivers.
+func (app *App) startStream(streamer *Streamer, streamData *TwitchStreamData, sendNotification bool, outbox ...*OutboxEntry) error {
+	session := newStreamSession(streamData)
+	if sendNotification {
+		event := newNotificationEvent(streamer, session)
+		event.ThumbnailURL = formatThumbnailURL(streamData.ThumbnailURL)
+		for _, entry := range app.outboxEntries(outboxLive, event, nil) {
+			entry.SessionStart = session.StartedAt
+			outbox = append(outbox, entry)
+		}
+	}
+
+	return app.saveStatus(streamer.UserID, true, session, outbox)
+}
+
+// endStream summarizes the live messages of a finished session and returns
+// the opt-in offline notifications, to be saved with the new status. The
+// stream is considered to have ended when it was first missed.
+func (app *App) endStream(streamer *Streamer, session *StreamSession, sendNotification bool) []*OutboxEntry {
+	endedAt := time.Now()
+	if session != nil {
+		if !session.FirstMissAt.IsZero() {
+			endedAt = session.FirstMissAt
+		}
+		app.editEndedMessages(streamer, session, endedAt)
+		app.streamerManager.addSessionRecord(session.record(streamer, endedAt), app.config.HistoryMaxSessions, app.config.HistoryMaxAge)
+	}
+
+	if !sendNotification {
+		return nil
+	}
+	event := newNotificationEvent(streamer, session)
+	event.EndedAt = endedAt
+	return app.outboxEntries(outboxOffline, event, offlineToggle)
+}
+
+// refreshLiveSession records the latest stream data for a streamer that is
+// still live, edits its live messages when the title or category changed (or
+// the viewer count, less often) and announces title or category changes once
+// the cooldown allows it.
+func (app *App) refreshLiveSession(streamer *Streamer, streamData *TwitchStreamData, sendNotification bool) error {
+	now := time.Now()
+	session := app.streamerManager.getSession(streamer.UserID)
+	changed := true
+	edit := false
+	recovered := false
+	if session == nil {
+		session = newStreamSession(streamData)
+	} else {
+		recovered = session.MissedChecks > 0
+		edit = session.needsEdit(streamData, now)
+		changed = session.update(streamData)
+	}
+
+	var outbox []*OutboxEntry
+	announced := false
+	if sendNotification {
+		titleChanged, gameChanged := session.pendingChanges(now, app.config.UpdateCooldown)
+		if titleChanged || gameChanged {
+			event := newNotificationEvent(streamer, session)
+			event.TitleChanged = titleChanged
+			event.GameChanged = gameChanged
+			outbox = app.outboxEntries(outboxUpdate, event, updatesToggle)
+			session.NotifiedTitle = session.Title
+			session.NotifiedGame = session.GameName
+			session.LastChangeNotified = now
+			announced = true
+		}
+	}
+
+	if !changed && !announced && !recovered {
+		return nil
+	}
+	if edit {
+		app.editLiveMessages(streamer, session)
+		session.MessagesEditedAt = now
+	}
+	return app.saveStatus(streamer.UserID, true, session, outbox)
+}
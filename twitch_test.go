@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfirmOffline(t *testing.T) {
+	tests := []struct {
+		name         string
+		threshold    int
+		gracePeriod  time.Duration
+		missedChecks int
+		// How long ago the stream was first missed, zero for never
+		missingFor time.Duration
+		want       bool
+	}{
+		{"first miss below threshold", 2, 0, 0, 0, false},
+		{"threshold reached", 2, 0, 1, 0, true},
+		{"threshold reached inside grace period", 2, 5 * time.Minute, 1, time.Minute, false},
+		{"threshold and grace period reached", 2, 5 * time.Minute, 1, 6 * time.Minute, true},
+		{"grace period reached below threshold", 3, 5 * time.Minute, 0, 10 * time.Minute, false},
+		{"single check without grace period", 1, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{config: Config{OfflineMissThreshold: tt.threshold, OfflineGracePeriod: tt.gracePeriod}}
+			session := &StreamSession{MissedChecks: tt.missedChecks}
+			if tt.missingFor > 0 {
+				session.FirstMissAt = time.Now().Add(-tt.missingFor)
+			}
+
+			if got := app.confirmOffline(session); got != tt.want {
+				t.Errorf("confirmOffline() = %v, want %v", got, tt.want)
+			}
+			if session.MissedChecks != tt.missedChecks+1 {
+				t.Errorf("MissedChecks = %d, want %d", session.MissedChecks, tt.missedChecks+1)
+			}
+			if session.FirstMissAt.IsZero() {
+				t.Error("FirstMissAt not set")
+			}
+		})
+	}
+}
+
+func TestIsDifferentBroadcast(t *testing.T) {
+	startedAt := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		session    StreamSession
+		streamData TwitchStreamData
+		want       bool
+	}{
+		{"same stream ID", StreamSession{StreamID: "1", StartedAt: startedAt}, TwitchStreamData{ID: "1", StartedAt: "2026-01-01T20:00:00Z"}, false},
+		{"new stream ID", StreamSession{StreamID: "1", StartedAt: startedAt}, TwitchStreamData{ID: "2", StartedAt: "2026-01-01T20:00:00Z"}, true},
+		{"no stream ID, same start", StreamSession{StartedAt: startedAt}, TwitchStreamData{StartedAt: "2026-01-01T20:00:00Z"}, false},
+		{"no stream ID, new start", StreamSession{StartedAt: startedAt}, TwitchStreamData{StartedAt: "2026-01-01T22:00:00Z"}, true},
+		{"no stream ID, unknown start", StreamSession{StartedAt: startedAt}, TwitchStreamData{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.session.isDifferentBroadcast(&tt.streamData); got != tt.want {
+				t.Errorf("isDifferentBroadcast() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAndUpdateStreamerStatusFlaps(t *testing.T) {
+	tests := []struct {
+		name string
+		// Stream ID seen after the stream was missed once
+		streamID     string
+		wantStreamID string
+		wantHistory  int
+		wantPeak     int
+	}{
+		{"same stream resumes", "stream1", "stream1", 0, 100},
+		{"new stream is a new broadcast", "stream2", "stream2", 1, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.config.OfflineMissThreshold = 2
+			app.config.HistoryMaxSessions = 10
+
+			streamer := &Streamer{Username: "streamer", DisplayName: "Streamer", UserID: "42"}
+			if err := app.streamerManager.addStreamer(streamer); err != nil {
+				t.Fatalf("addStreamer() error = %v", err)
+			}
+			streamer = app.findStreamerByUserID("42")
+
+			live := &TwitchStreamData{ID: "stream1", UserLogin: "streamer", Title: "Title", ViewerCount: 100, StartedAt: "2026-01-01T20:00:00Z"}
+			if err := app.checkAndUpdateStreamerStatus(streamer, live, false); err != nil {
+				t.Fatalf("going live: %v", err)
+			}
+
+			// A single miss stays below the threshold
+			if err := app.checkAndUpdateStreamerStatus(streamer, nil, false); err != nil {
+				t.Fatalf("missed check: %v", err)
+			}
+			session := app.streamerManager.getSession("42")
+			if session == nil || session.MissedChecks != 1 {
+				t.Fatalf("session after one miss = %+v, want still live with 1 missed check", session)
+			}
+
+			back := &TwitchStreamData{ID: tt.streamID, UserLogin: "streamer", Title: "Title", ViewerCount: 10, StartedAt: "2026-01-01T20:00:00Z"}
+			if err := app.checkAndUpdateStreamerStatus(streamer, back, false); err != nil {
+				t.Fatalf("stream back: %v", err)
+			}
+
+			session = app.streamerManager.getSession("42")
+			if session == nil {
+				t.Fatal("stream considered offline")
+			}
+			if session.StreamID != tt.wantStreamID || session.MissedChecks != 0 || !session.FirstMissAt.IsZero() {
+				t.Errorf("session = %+v, want stream %s with no missed checks", session, tt.wantStreamID)
+			}
+			if session.PeakViewers != tt.wantPeak {
+				t.Errorf("PeakViewers = %d, want %d", session.PeakViewers, tt.wantPeak)
+			}
+
+			history, err := app.streamerManager.getSessionHistory("streamer", 10)
+			if err != nil {
+				t.Fatalf("getSessionHistory() error = %v", err)
+			}
+			if len(history) != tt.wantHistory {
+				t.Errorf("history has %d sessions, want %d", len(history), tt.wantHistory)
+			}
+		})
+	}
+}
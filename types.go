@@ -2,33 +2,490 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gorilla/websocket"
 )
 
 type Config struct {
-	TwitchClientID     string
-	TwitchClientSecret string
-	TelegramBotToken   string
-	TelegramChatID     int64
-	PollingInterval    time.Duration
+	TwitchClientID       string
+	TwitchClientSecret   string
+	TwitchUserToken      string
+	WebhookCallbackURL   string
+	WebhookSecret        string
+	HTTPListenAddr       string
+	TelegramBotToken     string
+	TelegramChatID       int64
+	AllowedChatIDs       map[int64]bool
+	AllowAllChats        bool
+	PollingInterval      time.Duration
+	NotificationTemplate string
+	OfflineNotifications bool
+	UpdateNotifications  bool
+	UpdateCooldown       time.Duration
+	OfflineMissThreshold int
+	OfflineGracePeriod   time.Duration
+	StorageBackend       string
+	SQLitePath           string
+	HistoryMaxSessions   int
+	HistoryMaxAge        time.Duration
+	ReadyPollFactor      int
+	AdminChatID          int64
+	BreakerThreshold     int
+	BreakerCooldown      time.Duration
+	NotifyWebhookURLs    []string
+	NotifyWebhookSecret  string
+	NotifyWebhookTimeout time.Duration
+	SlackWebhookHosts    []string
+	MatrixHomeserverURL  string
+	MatrixAccessToken    string
+	NtfyServerURL        string
+	NtfyTopic            string
+	NtfyToken            string
+	NtfyPriority         int
+	NtfyTags             []string
+	GotifyURL            string
+	GotifyToken          string
+	GotifyPriority       int
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPFrom             string
+	SMTPTo               []string
+	SMTPSecurity         string
+	EmailMode            string
+	// Time of day the digest is sent, as an offset from local midnight
+	EmailDigestTime time.Duration
 }
 
 type Streamer struct {
-	Username    string    `json:"username"`
-	DisplayName string    `json:"display_name"`
-	UserID      string    `json:"user_id"`
-	IsLive      bool      `json:"is_live"`
-	LastChecked time.Time `json:"last_checked"`
+	Username    string         `json:"username"`
+	DisplayName string         `json:"display_name"`
+	UserID      string         `json:"user_id"`
+	IsLive      bool           `json:"is_live"`
+	LastChecked time.Time      `json:"last_checked"`
+	Subscribers []int64        `json:"subscribers"`
+	Session     *StreamSession `json:"session,omitempty"`
+}
+
+type StreamSession struct {
+	StreamID    string    `json:"stream_id"`
+	StartedAt   time.Time `json:"started_at"`
+	Title       string    `json:"title"`
+	GameName    string    `json:"game_name"`
+	ViewerCount int       `json:"viewer_count"`
+	PeakViewers int       `json:"peak_viewers"`
+	// Title and game last announced, used to detect changes worth notifying
+	NotifiedTitle      string        `json:"notified_title"`
+	NotifiedGame       string        `json:"notified_game"`
+	LastChangeNotified time.Time     `json:"last_change_notified"`
+	Games              []string      `json:"games"`
+	Titles             []string      `json:"titles"`
+	Messages           []LiveMessage `json:"messages"`
+	// Last time the live messages were sent or edited, to throttle edits
+	// for viewer counts alone
+	MessagesEditedAt time.Time `json:"messages_edited_at"`
+	// Viewer counts seen so far, for the average kept in the history
+	ViewerSamples int   `json:"viewer_samples"`
+	ViewerTotal   int64 `json:"viewer_total"`
+	// Consecutive checks that didn't see the stream, for flap suppression
+	MissedChecks int       `json:"missed_checks"`
+	FirstMissAt  time.Time `json:"first_miss_at"`
+}
+
+type LiveMessage struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int   `json:"message_id"`
+	IsPhoto   bool  `json:"is_photo"`
+}
+
+type ChatSettings struct {
+	ChatID               int64                     `json:"chat_id"`
+	Template             string                    `json:"template,omitempty"`
+	OfflineNotifications *bool                     `json:"offline_notifications,omitempty"`
+	UpdateNotifications  *bool                     `json:"update_notifications,omitempty"`
+	Streamers            map[string]*StreamerPrefs `json:"streamers,omitempty"`
+}
+
+type StreamerPrefs struct {
+	Template             string `json:"template,omitempty"`
+	OfflineNotifications *bool  `json:"offline_notifications,omitempty"`
+	UpdateNotifications  *bool  `json:"update_notifications,omitempty"`
+	// Where notifications for the streamer go; only this chat when empty
+	Targets []NotificationTarget `json:"targets,omitempty"`
+}
+
+// NotificationTarget is a destination of notifications: a Telegram chat, a
+// webhook URL or a Matrix room, depending on the notifier.
+type NotificationTarget struct {
+	Type   string `json:"type"`
+	ChatID int64  `json:"chat_id,omitempty"`
+	URL    string `json:"url,omitempty"`
+	RoomID string `json:"room_id,omitempty"`
+}
+
+// NotificationEvent describes a stream event independently of how each
+// notifier presents it.
+type NotificationEvent struct {
+	// Unique per event and shared by its targets, stable across retries
+	ID           string    `json:"id,omitempty"`
+	Time         time.Time `json:"time"`
+	StreamID     string    `json:"stream_id,omitempty"`
+	Username     string    `json:"username"`
+	UserID       string    `json:"user_id"`
+	DisplayName  string    `json:"display_name"`
+	Title        string    `json:"title,omitempty"`
+	GameName     string    `json:"game_name,omitempty"`
+	ViewerCount  int       `json:"viewer_count,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at,omitempty"`
+	TitleChanged bool      `json:"title_changed,omitempty"`
+	GameChanged  bool      `json:"game_changed,omitempty"`
+}
+
+// Notifier delivers stream events to one kind of target.
+type Notifier interface {
+	// Live announces that a stream started and returns the sent message if
+	// it can be edited as the stream goes on.
+	Live(target NotificationTarget, event *NotificationEvent) (*LiveMessage, error)
+	Offline(target NotificationTarget, event *NotificationEvent) error
+	Update(target NotificationTarget, event *NotificationEvent) error
+}
+
+// TelegramNotifier sends notifications through the bot.
+type TelegramNotifier struct {
+	app *App
+}
+
+// DiscordNotifier posts notifications as embeds to Discord webhooks.
+type DiscordNotifier struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+// WebhookNotifier POSTs signed JSON events to arbitrary URLs and logs every
+// delivery attempt.
+type WebhookNotifier struct {
+	ctx    context.Context
+	client *http.Client
+	// Client for webhooks added by chats, which may only reach public
+	// addresses
+	publicClient *http.Client
+	trustedURLs  []string
+	secret       string
+	logPath      string
+	logMutex     sync.Mutex
+}
+
+// WebhookPayload is the versioned body of outgoing webhook events.
+type WebhookPayload struct {
+	Version int              `json:"version"`
+	ID      string           `json:"id"`
+	Type    string           `json:"type"`
+	Time    time.Time        `json:"time"`
+	Stream  TwitchStreamData `json:"stream"`
+	EndedAt *time.Time       `json:"ended_at,omitempty"`
+	Changes *WebhookChanges  `json:"changes,omitempty"`
+}
+
+type WebhookChanges struct {
+	Title bool `json:"title"`
+	Game  bool `json:"game"`
+}
+
+// WebhookDelivery is a line of the webhook delivery log.
+type WebhookDelivery struct {
+	Time       time.Time `json:"time"`
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// SlackNotifier posts notifications as Block Kit messages to Slack incoming
+// webhooks.
+type SlackNotifier struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+// MatrixNotifier sends notifications as m.room.message events through the
+// Matrix client-server API, as the account of MATRIX_ACCESS_TOKEN.
+type MatrixNotifier struct {
+	ctx           context.Context
+	client        *http.Client
+	homeserverURL string
+	accessToken   string
+}
+
+// NtfyNotifier publishes notifications to the ntfy topic of NTFY_TOPIC.
+type NtfyNotifier struct {
+	ctx       context.Context
+	client    *http.Client
+	serverURL string
+	topic     string
+	token     string
+	priority  int
+	tags      []string
+}
+
+// GotifyNotifier pushes notifications to the Gotify application of
+// GOTIFY_TOKEN.
+type GotifyNotifier struct {
+	ctx       context.Context
+	client    *http.Client
+	serverURL string
+	token     string
+	priority  int
+}
+
+// EmailNotifier mails notifications to SMTP_TO as they happen, or a daily
+// digest of the streams in digest mode.
+type EmailNotifier struct {
+	ctx      context.Context
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+	security string
+	// TLS settings for STARTTLS and implicit TLS, verifying host by default
+	tlsConfig *tls.Config
+}
+
+// EmailMessage is a mail with plain-text and HTML alternatives.
+type EmailMessage struct {
+	ID      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type NtfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Tags     []string `json:"tags,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Attach   string   `json:"attach,omitempty"`
+}
+
+type GotifyMessage struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+type SlackWebhookPayload struct {
+	// Fallback for notifications and clients without Block Kit
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks,omitempty"`
+}
+
+type SlackBlock struct {
+	Type      string         `json:"type"`
+	Text      *SlackText     `json:"text,omitempty"`
+	Fields    []SlackText    `json:"fields,omitempty"`
+	Elements  []SlackElement `json:"elements,omitempty"`
+	ImageURL  string         `json:"image_url,omitempty"`
+	AltText   string         `json:"alt_text,omitempty"`
+	Accessory *SlackElement  `json:"accessory,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type SlackElement struct {
+	Type string     `json:"type"`
+	Text *SlackText `json:"text,omitempty"`
+	URL  string     `json:"url,omitempty"`
+}
+
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type MatrixJoinResponse struct {
+	RoomID string `json:"room_id"`
+}
+
+type DiscordWebhookPayload struct {
+	Embeds []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	URL         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Author      *DiscordEmbedAuthor `json:"author,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Image       *DiscordEmbedImage  `json:"image,omitempty"`
+}
+
+type DiscordEmbedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type DiscordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type NotificationToggle struct {
+	Name          string
+	Command       string
+	Default       func(config *Config) bool
+	ChatValue     func(chat *ChatSettings) **bool
+	StreamerValue func(prefs *StreamerPrefs) **bool
+}
+
+type NotificationData struct {
+	DisplayName string
+	Username    string
+	Title       string
+	Game        string
+	Viewers     int
+	URL         string
+	StartedAt   time.Time
+}
+
+type StreamersFile struct {
+	Version   int             `json:"version"`
+	Streamers []Streamer      `json:"streamers"`
+	Chats     []ChatSettings  `json:"chats,omitempty"`
+	History   []SessionRecord `json:"history,omitempty"`
+	Outbox    []OutboxEntry   `json:"outbox,omitempty"`
+}
+
+// OutboxEntry is a notification waiting to be delivered to one target. Entries
+// are saved together with the status change that produced them and removed
+// once delivered, so a crash or restart in between only delays them.
+type OutboxEntry struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Chat whose subscription produced the notification, 0 for global targets
+	ChatID   int64  `json:"chat_id"`
+	Username string `json:"username"`
+	UserID   string `json:"user_id"`
+	// Start of the stream session a live notification belongs to
+	SessionStart  time.Time          `json:"session_start,omitempty"`
+	Target        NotificationTarget `json:"target"`
+	Event         NotificationEvent  `json:"event"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Attempts      int                `json:"attempts,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at,omitempty"`
+	LastError     string             `json:"last_error,omitempty"`
+}
+
+// SessionRecord is a finished stream kept in the history.
+type SessionRecord struct {
+	Username       string    `json:"username"`
+	UserID         string    `json:"user_id"`
+	DisplayName    string    `json:"display_name"`
+	StartedAt      time.Time `json:"started_at"`
+	EndedAt        time.Time `json:"ended_at"`
+	Titles         []string  `json:"titles"`
+	Games          []string  `json:"games"`
+	PeakViewers    int       `json:"peak_viewers"`
+	AverageViewers int       `json:"average_viewers"`
 }
 
 type StreamerManager struct {
 	streamers map[string]*Streamer
+	chats     map[int64]*ChatSettings
 	mutex     sync.RWMutex
-	filename  string
+	store     Store
+}
+
+// Store persists streamers, their subscriptions and chat settings. The
+// StreamerManager keeps everything in memory and calls the store on every
+// change.
+type Store interface {
+	ListStreamers() ([]Streamer, error)
+	ListChats() ([]ChatSettings, error)
+	AddStreamer(streamer *Streamer) error
+	RemoveStreamer(username string) error
+	// UpdateStreamerStatus saves the live status and session of a streamer
+	// and enqueues the notifications it triggered in the same write.
+	UpdateStreamerStatus(streamer *Streamer, outbox []*OutboxEntry) error
+	AddSubscriber(username string, chatID int64) error
+	RemoveSubscriber(username string, chatID int64) error
+	SaveChat(chat *ChatSettings) error
+	AddSessionRecord(record *SessionRecord) error
+	// ListSessionRecords returns the most recent sessions of a streamer,
+	// newest first.
+	ListSessionRecords(username string, limit int) ([]SessionRecord, error)
+	// PruneSessionRecords keeps at most keep sessions of a streamer and drops
+	// sessions that ended before cutoff (if set).
+	PruneSessionRecords(username string, keep int, cutoff time.Time) error
+	// ListOutboxEntries returns the pending notifications, oldest first.
+	ListOutboxEntries() ([]OutboxEntry, error)
+	UpdateOutboxEntry(entry *OutboxEntry) error
+	RemoveOutboxEntry(id int64) error
+	Close() error
+}
+
+// JSONStore keeps the whole state in a single JSON file rewritten on every
+// change.
+type JSONStore struct {
+	filename     string
+	legacyChatID int64
+	streamers    map[string]*Streamer
+	chats        map[int64]*ChatSettings
+	history      []SessionRecord
+	outbox       []OutboxEntry
+	nextOutboxID int64
+	mutex        sync.Mutex
+}
+
+// SQLiteStore keeps the state in an embedded SQLite database so status
+// updates only touch the affected row.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// APIStatusError is returned for Twitch API and webhook responses with an
+// unexpected status code.
+type APIStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+// TwitchValidateResponse is the body of a successful
+// id.twitch.tv/oauth2/validate request.
+type TwitchValidateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
 }
 
 type TwitchTokenResponse struct {
@@ -50,13 +507,15 @@ type TwitchStreamResponse struct {
 }
 
 type TwitchStreamData struct {
-	UserID      string `json:"user_id"`
-	UserLogin   string `json:"user_login"`
-	UserName    string `json:"user_name"`
-	GameName    string `json:"game_name"`
-	Title       string `json:"title"`
-	ViewerCount int    `json:"viewer_count"`
-	StartedAt   string `json:"started_at"`
+	ID           string `json:"id"`
+	UserID       string `json:"user_id"`
+	UserLogin    string `json:"user_login"`
+	UserName     string `json:"user_name"`
+	GameName     string `json:"game_name"`
+	Title        string `json:"title"`
+	ViewerCount  int    `json:"viewer_count"`
+	StartedAt    string `json:"started_at"`
+	ThumbnailURL string `json:"thumbnail_url"`
 }
 
 type App struct {
@@ -65,9 +524,213 @@ type App struct {
 	bot             *tgbotapi.BotAPI
 	twitchToken     string
 	tokenExpiry     time.Time
+	tokenMutex      sync.Mutex
 	ctx             context.Context
 	cancel          context.CancelFunc
 	pollingTicker   *time.Ticker
 	pollingMutex    sync.Mutex
+	statusMutex     sync.Mutex
 	httpClient      *http.Client
+	eventSub        *EventSubClient
+	webhook         *EventSubWebhook
+	httpMux         *http.ServeMux
+	httpServer      *http.Server
+	metrics         *Metrics
+	startedAt       time.Time
+	rateLimiter     *RateLimiter
+	userRateLimiter *RateLimiter
+	twitchBreaker   *CircuitBreaker
+	telegram        *TelegramQueue
+	outboxWake      chan struct{}
+	notifiers       map[string]Notifier
+	deadLetterPath  string
+}
+
+// TelegramQueue serializes outgoing Telegram requests per chat, spacing them
+// to stay within Telegram's per-chat and global limits and retrying 429s
+// after the retry_after they carry.
+type TelegramQueue struct {
+	bot    *tgbotapi.BotAPI
+	ctx    context.Context
+	global *RateLimiter
+	chats  map[int64]chan *telegramJob
+	mutex  sync.Mutex
+}
+
+type telegramJob struct {
+	chatID  int64
+	message tgbotapi.Chattable
+	result  chan telegramResult
+}
+
+type telegramResult struct {
+	message tgbotapi.Message
+	err     error
+}
+
+// DeadLetter is a notification the outbox gave up on, after every retry or
+// on a permanent error, appended to the dead-letter log so it can be
+// inspected or resent by hand.
+type DeadLetter struct {
+	Time     time.Time          `json:"time"`
+	Kind     string             `json:"kind"`
+	ChatID   int64              `json:"chat_id,omitempty"`
+	Username string             `json:"username"`
+	Target   NotificationTarget `json:"target"`
+	Event    NotificationEvent  `json:"event"`
+	Error    string             `json:"error"`
+	Attempts int                `json:"attempts"`
+}
+
+// CircuitBreaker opens after a number of consecutive Twitch API failures so
+// polling pauses instead of hammering an unreachable API. Once the cooldown
+// has elapsed a single probe is let through; its result closes the breaker
+// or keeps it open for another cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	open      bool
+	openedAt  time.Time
+	probeAt   time.Time
+	mutex     sync.Mutex
+}
+
+// RateLimiter is a token bucket refilling capacity tokens per window. For
+// Twitch it mirrors the per-token Helix rate limit and is resynchronized with
+// the Ratelimit-* headers of every response.
+type RateLimiter struct {
+	name         string
+	window       time.Duration
+	capacity     float64
+	tokens       float64
+	lastRefill   time.Time
+	blockedUntil time.Time
+	mutex        sync.Mutex
+}
+
+// HealthResponse is the JSON body of /healthz and /readyz.
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+type ComponentHealth struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	AgeSeconds  *int64     `json:"age_seconds,omitempty"`
+}
+
+// Metrics holds the Prometheus counters exposed on /metrics. Gauges are
+// computed from the current state when scraped.
+type Metrics struct {
+	polls              *CounterVec
+	twitchAPIRequests  *CounterVec
+	tokenRefreshes     *CounterVec
+	notifications      *CounterVec
+	commands           *CounterVec
+	lastSuccessfulPoll atomic.Int64
+	lastTelegramUpdate atomic.Int64
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]uint64
+	mutex  sync.Mutex
+}
+
+type EventSubClient struct {
+	conn          *websocket.Conn
+	sessionID     string
+	connected     bool
+	subscriptions map[string][]string
+	mutex         sync.Mutex
+}
+
+// eventSubFrame is a message read from one of the EventSub connections, or
+// the error that ended it.
+type eventSubFrame struct {
+	conn *websocket.Conn
+	msg  EventSubMessage
+	err  error
+}
+
+type EventSubWebhook struct {
+	subscriptions map[string][]string
+	pending       map[string]string
+	// IDs of the messages handled, with the time they were sent
+	seenMessages map[string]time.Time
+	mutex        sync.Mutex
+}
+
+type EventSubWebhookBody struct {
+	Challenge    string                `json:"challenge"`
+	Subscription *EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage       `json:"event"`
+}
+
+type EventSubMessage struct {
+	Metadata EventSubMetadata `json:"metadata"`
+	Payload  EventSubPayload  `json:"payload"`
+}
+
+type EventSubMetadata struct {
+	MessageID           string `json:"message_id"`
+	MessageType         string `json:"message_type"`
+	MessageTimestamp    string `json:"message_timestamp"`
+	SubscriptionType    string `json:"subscription_type"`
+	SubscriptionVersion string `json:"subscription_version"`
+}
+
+type EventSubPayload struct {
+	Session      *EventSubSession      `json:"session"`
+	Subscription *EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage       `json:"event"`
+}
+
+type EventSubSession struct {
+	ID                      string `json:"id"`
+	Status                  string `json:"status"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+type EventSubSubscription struct {
+	ID        string            `json:"id,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Status    string            `json:"status,omitempty"`
+	Condition EventSubCondition `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+}
+
+type EventSubCondition struct {
+	BroadcasterUserID string `json:"broadcaster_user_id"`
+}
+
+type EventSubTransport struct {
+	Method    string `json:"method"`
+	SessionID string `json:"session_id,omitempty"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+type EventSubSubscriptionResponse struct {
+	Data       []EventSubSubscription `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+type EventSubStreamEvent struct {
+	ID                   string `json:"id"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	Type                 string `json:"type"`
+	StartedAt            string `json:"started_at"`
 }